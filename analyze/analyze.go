@@ -1,39 +1,90 @@
 package analyze
 
 import (
-	"golang.org/x/tools/go/callgraph"
+	"errors"
 
+	"github.com/bytecamp2021-calldiff/calldiff/common"
 	"github.com/bytecamp2021-calldiff/calldiff/view"
 )
 
 //给diffGraph添加上点集
 func makeDiffNode(oldGraph *Graph, newGraph *Graph, diffGraph *view.DiffGraph) {
 	//求出删去的接口
-	for key := range oldGraph.nodes {
+	for key, node1 := range oldGraph.nodes {
 		if _, ok := newGraph.nodes[key]; !ok {
 			diffGraph.Nodes[key] = view.NewDiffNodeHelper()
 			diffGraph.Nodes[key].Name = key
 			diffGraph.Nodes[key].Difference = view.REMOVED
+			diffGraph.Nodes[key].Kind = node1.kind
+			diffGraph.Nodes[key].EntryKinds = entryKinds(node1)
+			if node1.kind == view.NamedType {
+				diffGraph.Nodes[key].TypeChange = &view.TypeChange{Kind: node1.typ.Kind}
+			}
+			diffGraph.Nodes[key].InstancesRemoved = node1.instances
 		}
 	}
 	//求出新增的接口和一直有的接口（class暂标为1）
 	for key, node2 := range newGraph.nodes {
 		diffGraph.Nodes[key] = view.NewDiffNodeHelper()
 		diffGraph.Nodes[key].Name = key
+		diffGraph.Nodes[key].Kind = node2.kind
+		diffGraph.Nodes[key].EntryKinds = entryKinds(node2)
+		if node2.kind == view.NamedType {
+			diffGraph.Nodes[key].TypeChange = &view.TypeChange{Kind: node2.typ.Kind}
+		}
 		if node1, ok := oldGraph.nodes[key]; !ok {
 			diffGraph.Nodes[key].Difference = view.INSERTED
+			diffGraph.Nodes[key].InstancesAdded = node2.instances
 		} else {
-			if isEqual(node1, node2) {
-				diffGraph.Nodes[key].Difference = view.UNCHANGED
-			} else {
+			diffGraph.Nodes[key].InstancesAdded = subtractStrings(node2.instances, node1.instances)
+			diffGraph.Nodes[key].InstancesRemoved = subtractStrings(node1.instances, node2.instances)
+			if !isEqual(node1, node2) && node2.kind == view.NamedType {
+				//类型的字段、标签或方法集改变
+				diffGraph.Nodes[key].Difference = view.CHANGED
+				diffGraph.Nodes[key].Reason = view.TypeChanged
+				diffGraph.Nodes[key].Changes, diffGraph.Nodes[key].TypeChange = typeChange(&node1.typ, &node2.typ)
+				diffGraph.Nodes[key].Source = sourceDiff(node1, node2)
+			} else if !isEqual(node1, node2) && node2.kind != "" {
+				//全局变量的初始值或常量的值改变
 				diffGraph.Nodes[key].Difference = view.CHANGED
+				diffGraph.Nodes[key].Reason = view.ValueChanged
+				diffGraph.Nodes[key].Changes = []string{ChangeValue}
+				diffGraph.Nodes[key].Source = sourceDiff(node1, node2)
+			} else if !isEqual(node1, node2) {
+				diffGraph.Nodes[key].Difference = view.CHANGED
+				diffGraph.Nodes[key].Reason = view.BodyChanged
+				diffGraph.Nodes[key].Changes = classifyChanges(node1, node2)
+				diffGraph.Nodes[key].Source = sourceDiff(node1, node2)
+			} else if !isCallEqual(node1, node2) {
+				//代码不变但调用的函数集合改变（如接口实现的增删导致动态调用的目标改变）
+				diffGraph.Nodes[key].Difference = view.CHANGED
+				diffGraph.Nodes[key].Reason = view.CallStructureChanged
+				diffGraph.Nodes[key].Changes = []string{ChangeCalls}
+				diffGraph.Nodes[key].Source = sourceDiff(node1, node2)
+			} else {
+				diffGraph.Nodes[key].Difference = view.UNCHANGED
 			}
 		}
 	}
 }
 
+// subtractStrings 返回 a 中有而 b 中没有的元素
+func subtractStrings(a []string, b []string) []string {
+	set := make(map[string]bool)
+	for _, s := range b {
+		set[s] = true
+	}
+	var result []string
+	for _, s := range a {
+		if !set[s] {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
 //给diffGraph添加上两边均有的调用
-//建立强连通图,并求出各连通部分的是否改变,并将强连通图上的改变映射回原图
+//建立强连通图,并求出各连通部分的是否改变（代码改变或调用结构改变）,并将强连通图上的改变映射回原图
 func makeSameEdge(oldGraph *Graph, newGraph *Graph, diffGraph *view.DiffGraph) {
 	var interGraph = intersectGraph(oldGraph, newGraph)
 	var sccGraph = makeSccGraph(interGraph)
@@ -41,7 +92,8 @@ func makeSameEdge(oldGraph *Graph, newGraph *Graph, diffGraph *view.DiffGraph) {
 	for key, value := range interGraph.nodes {
 		for callName := range value.callEdge {
 			diffGraph.Nodes[key].CallEdge[callName] = view.NewDiffEdgeHelper(diffGraph.Nodes[callName])
-			if sccGraph.belongs[callName].isChanged {
+			com := sccGraph.belongs[callName]
+			if com.isChanged || com.isStructChanged {
 				diffGraph.Nodes[key].CallEdge[callName].Difference = view.CHANGED
 			} else {
 				diffGraph.Nodes[key].CallEdge[callName].Difference = view.UNCHANGED
@@ -50,22 +102,44 @@ func makeSameEdge(oldGraph *Graph, newGraph *Graph, diffGraph *view.DiffGraph) {
 	}
 }
 
-//给diffGraph添加上新增或者删去的边集
-func makeDiffEdge(oldGraph *Graph, newGraph *Graph, diffGraph *view.DiffGraph) {
+//给diffGraph添加上新增或者删去的边集，moved 为移动或重命名的函数的新名称到旧名称的映射
+//与移动或重命名的函数相连的边不在两图的交集中，两个版本中都有的这类调用在这里添加
+func makeDiffEdge(oldGraph *Graph, newGraph *Graph, diffGraph *view.DiffGraph, moved map[string]string) {
+	oldNameOf := func(name string) string {
+		if oldName, ok := moved[name]; ok {
+			return oldName
+		}
+		return name
+	}
+	newNameOf := make(map[string]string)
+	for newName, oldName := range moved {
+		newNameOf[oldName] = newName
+	}
 	for key, value := range diffGraph.Nodes {
-		if value.Difference == view.UNCHANGED || value.Difference == view.CHANGED {
+		if value.Difference == view.UNCHANGED || value.Difference == view.CHANGED ||
+			value.Difference == view.MOVED || value.Difference == view.RENAMED {
+			oldNode, newNode := oldGraph.nodes[oldNameOf(key)], newGraph.nodes[key]
+			_, isMoved := moved[key]
 			//添加新增的调用
-			for callName := range newGraph.nodes[key].callEdge {
-				if _, ok := oldGraph.nodes[key].callEdge[callName]; !ok {
+			for callName := range newNode.callEdge {
+				_, calleeMoved := moved[callName]
+				if _, ok := oldNode.callEdge[oldNameOf(callName)]; !ok {
 					value.CallEdge[callName] = view.NewDiffEdgeHelper(diffGraph.Nodes[callName])
 					value.CallEdge[callName].Difference = view.INSERTED
+				} else if isMoved || calleeMoved {
+					value.CallEdge[callName] = view.NewDiffEdgeHelper(diffGraph.Nodes[callName])
+					value.CallEdge[callName].Difference = view.UNCHANGED
 				}
 			}
 			//添加删去的调用
-			for callName := range oldGraph.nodes[key].callEdge {
-				if _, ok := newGraph.nodes[key].callEdge[callName]; !ok {
-					value.CallEdge[callName] = view.NewDiffEdgeHelper(diffGraph.Nodes[callName])
-					value.CallEdge[callName].Difference = view.REMOVED
+			for callName := range oldNode.callEdge {
+				newName := callName
+				if name, ok := newNameOf[callName]; ok {
+					newName = name
+				}
+				if _, ok := newNode.callEdge[newName]; !ok {
+					value.CallEdge[newName] = view.NewDiffEdgeHelper(diffGraph.Nodes[newName])
+					value.CallEdge[newName].Difference = view.REMOVED
 				}
 			}
 		} else if value.Difference == view.INSERTED {
@@ -75,21 +149,49 @@ func makeDiffEdge(oldGraph *Graph, newGraph *Graph, diffGraph *view.DiffGraph) {
 			}
 		} else if value.Difference == view.REMOVED {
 			for callName := range oldGraph.nodes[key].callEdge {
-				value.CallEdge[callName] = view.NewDiffEdgeHelper(diffGraph.Nodes[callName])
-				value.CallEdge[callName].Difference = view.REMOVED
+				newName := callName
+				if name, ok := newNameOf[callName]; ok {
+					newName = name
+				}
+				value.CallEdge[newName] = view.NewDiffEdgeHelper(diffGraph.Nodes[newName])
+				value.CallEdge[newName].Difference = view.REMOVED
+			}
+		}
+	}
+}
+
+//标出指向全局变量、常量与类型的边的访问方式，它们不会被移动或重命名，新旧版本中名称一致
+func makeAccessEdge(oldGraph *Graph, newGraph *Graph, diffGraph *view.DiffGraph, moved map[string]string) {
+	for key, value := range diffGraph.Nodes {
+		oldName := key
+		if name, ok := moved[key]; ok {
+			oldName = name
+		}
+		for callName, edge := range value.CallEdge {
+			if node, ok := newGraph.nodes[key]; ok && node.access[callName] != "" {
+				edge.Access = node.access[callName]
+			} else if node, ok := oldGraph.nodes[oldName]; ok {
+				edge.Access = node.access[callName]
 			}
 		}
 	}
 }
 
 // GetDiff 找到两幅图的差异
-func GetDiff(oldCallGraph *callgraph.Graph, newCallGraph *callgraph.Graph) *view.DiffGraph {
-	var oldGraph = callGraph2graph(oldCallGraph)
-	var newGraph = callGraph2graph(newCallGraph)
+func GetDiff(oldGraph *Graph, newGraph *Graph) (*view.DiffGraph, error) {
+	if oldGraph == nil || newGraph == nil {
+		return nil, common.WrapError(common.ExitAnalysisError, errors.New("call graph is missing"))
+	}
 	var diffGraph = view.NewDiffGraphHelper()
+	diffGraph.Module = newGraph.module
+	if diffGraph.Module == "" {
+		diffGraph.Module = oldGraph.module
+	}
 	makeDiffNode(oldGraph, newGraph, diffGraph)
+	moved := matchMoved(oldGraph, newGraph, diffGraph)
 	makeSameEdge(oldGraph, newGraph, diffGraph)
-	makeDiffEdge(oldGraph, newGraph, diffGraph)
+	makeDiffEdge(oldGraph, newGraph, diffGraph, moved)
+	makeAccessEdge(oldGraph, newGraph, diffGraph, moved)
 	diffGraph.CalcAffected() // 计算哪些节点是黄色节点/受影响节点
-	return diffGraph
+	return diffGraph, nil
 }
@@ -63,6 +63,59 @@ func TestMakeDiffEdge(t *testing.T) {
 	var g3 = view.NewDiffGraphHelper()
 	makeDiffNode(g1, g2, g3)
 	makeSameEdge(g1, g2, g3)
-	makeDiffEdge(g1, g2, g3)
+	makeDiffEdge(g1, g2, g3, nil)
 	printDiffNode(g3)
 }
+
+// makeCallGraph 按 calls 构造调用图，calls[i] 为 {调用者, 被调用者}，各函数的 hash 相同
+func makeCallGraph(names []string, calls [][]string) *Graph {
+	g := newGraphHelper()
+	for _, name := range names {
+		g.nodes[name] = newNodeHelper()
+		g.nodes[name].name = name
+	}
+	for _, call := range calls {
+		g.nodes[call[0]].callEdge[call[1]] = g.nodes[call[1]]
+		g.nodes[call[1]].callByEdge[call[0]] = g.nodes[call[0]]
+	}
+	return g
+}
+
+func TestCallStructureChanged(t *testing.T) {
+	// store 的代码不变，但新增的接口实现 y 使其多了一个调用目标
+	oldGraph := makeCallGraph([]string{"main", "handler", "store", "x"},
+		[][]string{{"main", "handler"}, {"handler", "store"}, {"store", "x"}})
+	newGraph := makeCallGraph([]string{"main", "handler", "store", "x", "y"},
+		[][]string{{"main", "handler"}, {"handler", "store"}, {"store", "x"}, {"store", "y"}})
+	newGraph.nodes["x"].hashNum[0] = 1
+
+	diffGraph, err := GetDiff(oldGraph, newGraph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name       string
+		difference view.DiffType
+		reason     string
+		distance   int
+	}{
+		{"x", view.CHANGED, view.BodyChanged, 0},
+		{"store", view.CHANGED, view.CallStructureChanged, 0},
+		{"handler", view.AFFECTED, "", 1},
+		{"main", view.AFFECTED, "", 2},
+		{"y", view.INSERTED, "", -1},
+	}
+	for _, c := range cases {
+		n := diffGraph.Nodes[c.name]
+		if n.Difference != c.difference || n.Reason != c.reason || n.Distance != c.distance {
+			t.Errorf("%s: difference %d, reason %q, distance %d; want %d, %q, %d",
+				c.name, n.Difference, n.Reason, n.Distance, c.difference, c.reason, c.distance)
+		}
+	}
+	if causes := diffGraph.Nodes["main"].RootCauses; len(causes) != 2 || causes["store"] != 2 || causes["x"] != 3 {
+		t.Errorf("main root causes = %v", causes)
+	}
+	if edge := diffGraph.Nodes["handler"].CallEdge["store"]; edge.Difference != view.CHANGED {
+		t.Error("edge handler -> store should be marked as changed")
+	}
+}
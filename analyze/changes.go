@@ -0,0 +1,81 @@
+package analyze
+
+// 代码改变的函数具体改变的内容
+const (
+	ChangeSignature           = "signature_changed"       // 签名改变
+	ChangeReceiver            = "receiver_changed"        // 接收者类型改变
+	ChangeParamsAdded         = "params_added"            // 新增了参数
+	ChangeParamsRemoved       = "params_removed"          // 删去了参数
+	ChangeParamsChanged       = "params_changed"          // 参数个数不变，类型改变
+	ChangeReturns             = "returns_changed"         // 返回值改变
+	ChangeConstants           = "constants_changed"       // 引用的常量改变
+	ChangeGlobalsReadAdded    = "globals_read_added"      // 新读取了全局变量
+	ChangeGlobalsReadRemoved  = "globals_read_removed"    // 不再读取某些全局变量
+	ChangeGlobalsWriteAdded   = "globals_written_added"   // 新写入了全局变量
+	ChangeGlobalsWriteRemoved = "globals_written_removed" // 不再写入某些全局变量
+	ChangePanicsAdded         = "panics_added"            // 新增了 panic
+	ChangePanicsRemoved       = "panics_removed"          // 删去了 panic
+	ChangeLoops               = "loops_changed"           // 循环的个数改变
+	ChangeControlFlow         = "control_flow_changed"    // 控制流改变
+	ChangeCalls               = "calls_changed"           // 调用的函数改变
+	ChangeBody                = "body_changed"            // 以上均未改变，仅函数体中的其他代码改变
+	ChangeValue               = "value_changed"           // 全局变量的初始值或常量的值改变
+)
+
+// classifyChanges 比较函数在两个版本中的结构化信息，列出具体改变的内容
+func classifyChanges(n1 *Node, n2 *Node) []string {
+	var changes []string
+	add := func(changed bool, change string) {
+		if changed {
+			changes = append(changes, change)
+		}
+	}
+	f1, f2 := &n1.facts, &n2.facts
+	add(f1.Recv != f2.Recv || !equalStrings(f1.Params, f2.Params) ||
+		!equalStrings(f1.Results, f2.Results) || f1.Variadic != f2.Variadic, ChangeSignature)
+	add(f1.Recv != f2.Recv, ChangeReceiver)
+	add(len(f2.Params) > len(f1.Params), ChangeParamsAdded)
+	add(len(f2.Params) < len(f1.Params), ChangeParamsRemoved)
+	add(len(f2.Params) == len(f1.Params) && (!equalStrings(f1.Params, f2.Params) || f1.Variadic != f2.Variadic), ChangeParamsChanged)
+	add(!equalStrings(f1.Results, f2.Results), ChangeReturns)
+	add(!equalStrings(f1.Consts, f2.Consts), ChangeConstants)
+	add(hasAdded(f1.GlobalsRead, f2.GlobalsRead), ChangeGlobalsReadAdded)
+	add(hasAdded(f2.GlobalsRead, f1.GlobalsRead), ChangeGlobalsReadRemoved)
+	add(hasAdded(f1.GlobalsWritten, f2.GlobalsWritten), ChangeGlobalsWriteAdded)
+	add(hasAdded(f2.GlobalsWritten, f1.GlobalsWritten), ChangeGlobalsWriteRemoved)
+	add(f2.Panics > f1.Panics, ChangePanicsAdded)
+	add(f2.Panics < f1.Panics, ChangePanicsRemoved)
+	add(f1.Loops != f2.Loops, ChangeLoops)
+	add(f1.ControlFlow != f2.ControlFlow, ChangeControlFlow)
+	add(!isCallEqual(n1, n2), ChangeCalls)
+	if len(changes) == 0 && !isEqual(n1, n2) {
+		changes = append(changes, ChangeBody)
+	}
+	return changes
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hasAdded 判断 b 中是否有 a 中没有的元素
+func hasAdded(a []string, b []string) bool {
+	set := make(map[string]bool)
+	for _, s := range a {
+		set[s] = true
+	}
+	for _, s := range b {
+		if !set[s] {
+			return true
+		}
+	}
+	return false
+}
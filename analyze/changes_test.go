@@ -0,0 +1,116 @@
+package analyze
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+const changesOld = `package main
+
+var limit = 10
+
+var hits int
+
+func check(n int) bool {
+	return n < limit
+}
+
+func record(n int) {
+	hits += n
+}
+
+func total(values []int) int {
+	sum := 0
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
+
+func scale(n int) int {
+	return n * 2
+}
+
+func main() {
+	record(total([]int{1, 2}))
+	println(check(3), scale(4))
+}
+`
+
+const changesNew = `package main
+
+var limit = 10
+
+var hits int
+
+func check(n int, strict bool) bool {
+	if strict {
+		return n < limit
+	}
+	return true
+}
+
+func record(n int) {
+	if n < 0 {
+		panic("negative")
+	}
+	println(hits, limit)
+}
+
+func total(values []int) int {
+	sum := 0
+	for _, v := range values {
+		for i := 0; i < v; i++ {
+			sum++
+		}
+	}
+	return sum
+}
+
+func scale(n int) int {
+	return n * 3
+}
+
+func main() {
+	record(total([]int{1, 2}))
+	println(check(3, true), scale(4))
+}
+`
+
+func TestClassifyChanges(t *testing.T) {
+	oldGraph := buildHashGraph(t, changesOld, HashSSA)
+	newGraph := buildHashGraph(t, changesNew, HashSSA)
+	// 编码后再解码，结构化信息需要随缓存保存
+	newGraph = roundTrip(t, newGraph)
+	diffGraph, err := GetDiff(oldGraph, newGraph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string][]string{
+		"check": {ChangeSignature, ChangeParamsAdded, ChangeConstants, ChangeControlFlow},
+		"record": {ChangeConstants, ChangeGlobalsReadAdded, ChangeGlobalsWriteRemoved,
+			ChangePanicsAdded, ChangeControlFlow},
+		"total": {ChangeLoops, ChangeControlFlow},
+		"scale": {ChangeConstants},
+		"main":  {ChangeConstants},
+	}
+	for name, want := range cases {
+		node := diffGraph.Nodes["example.com/fixture#main#"+name+"#"]
+		if !reflect.DeepEqual(node.Changes, want) {
+			t.Errorf("%s: changes %v, want %v", name, node.Changes, want)
+		}
+	}
+}
+
+func roundTrip(t *testing.T, g *Graph) *Graph {
+	var b bytes.Buffer
+	if err := g.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeGraph(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
@@ -0,0 +1,117 @@
+package analyze
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/types"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// closureKeyLen 闭包名称中结构化标识的长度
+const closureKeyLen = 8
+
+// funcNamer 为函数生成调用图中的名称。
+// ssa 按源代码中出现的顺序将闭包命名为 F$1、F$2，在前面插入一个闭包会使其后的闭包全部改名，
+// 因此闭包使用外层函数的名称加上与位置无关的结构化标识（规范化指纹的前缀）命名，如 F$3fa4c2d1，
+// 同一个外层函数中指纹相同的闭包按出现顺序加上 -2、-3 区分
+type funcNamer struct {
+	names map[*ssa.Function]string
+}
+
+func newFuncNamer() *funcNamer {
+	return &funcNamer{names: make(map[*ssa.Function]string)}
+}
+
+func (n *funcNamer) name(f *ssa.Function) string {
+	if name, ok := n.names[f]; ok {
+		return name
+	}
+	parent := f.Parent()
+	if parent == nil {
+		n.names[f] = func2str(f)
+		return n.names[f]
+	}
+	// 一次为外层函数中的所有闭包命名
+	parentName := n.name(parent)
+	splits := strings.Split(parentName, "#")
+	count := make(map[string]int)
+	for _, anon := range parent.AnonFuncs {
+		hash := getNormalizedHash(anon)
+		key := hex.EncodeToString(hash[:])[:closureKeyLen]
+		count[key]++
+		if count[key] > 1 {
+			key = fmt.Sprintf("%s-%d", key, count[key])
+		}
+		splits[2] = strings.Split(parentName, "#")[2] + "$" + key
+		n.names[anon] = strings.Join(splits, "#")
+	}
+	return n.names[f]
+}
+
+// genericOrigin 返回泛型函数的实例（包括实例中的闭包）对应的泛型函数，其他函数返回其本身
+func genericOrigin(f *ssa.Function) *ssa.Function {
+	if origin := f.Origin(); origin != nil {
+		return origin
+	}
+	parent := f.Parent()
+	if parent == nil {
+		return f
+	}
+	origin := genericOrigin(parent)
+	if origin == parent {
+		return f
+	}
+	for i, anon := range parent.AnonFuncs {
+		if anon == f && i < len(origin.AnonFuncs) {
+			return origin.AnonFuncs[i]
+		}
+	}
+	return f
+}
+
+// typeArgs 返回泛型函数实例的类型实参，如 [int, example.com/m.T]
+func typeArgs(f *ssa.Function) string {
+	var args []string
+	for _, arg := range f.TypeArgs() {
+		args = append(args, types.TypeString(arg, nil))
+	}
+	return "[" + strings.Join(args, ", ") + "]"
+}
+
+// topLevel 返回闭包最外层的函数，不是闭包的函数返回其本身
+func topLevel(f *ssa.Function) *ssa.Function {
+	for f.Parent() != nil {
+		f = f.Parent()
+	}
+	return f
+}
+
+// nestedClosures 返回函数中定义的所有闭包，包括闭包中的闭包
+func nestedClosures(f *ssa.Function) []*ssa.Function {
+	var result []*ssa.Function
+	for _, anon := range f.AnonFuncs {
+		result = append(result, anon)
+		result = append(result, nestedClosures(anon)...)
+	}
+	return result
+}
+
+// mergeClosures 将闭包的指纹与结构化信息合并到其最外层的函数中，closures 为最外层函数的名称到其中各个闭包的映射
+func mergeClosures(g *Graph, closures map[string][]*Node) {
+	for ownerName, members := range closures {
+		owner := g.nodes[ownerName]
+		sort.Slice(members, func(i, j int) bool { return members[i].name < members[j].name })
+		h := sha256.New()
+		h.Write(owner.hashNum[:])
+		for _, member := range members {
+			h.Write([]byte(member.name))
+			h.Write(member.hashNum[:])
+			owner.facts.merge(&member.facts)
+		}
+		copy(owner.hashNum[:], h.Sum(nil))
+	}
+}
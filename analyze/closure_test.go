@@ -0,0 +1,284 @@
+package analyze
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+	"github.com/bytecamp2021-calldiff/calldiff/view"
+)
+
+const closuresOld = `package main
+
+func register(f func() int) {
+	println(f())
+}
+
+func routes() {
+	register(func() int { return 1 })
+	register(func() int { return 2 })
+}
+
+func main() {
+	routes()
+}
+`
+
+// closuresInserted 在已有的闭包之前插入了一个闭包
+const closuresInserted = `package main
+
+func register(f func() int) {
+	println(f())
+}
+
+func routes() {
+	register(func() int { return 0 })
+	register(func() int { return 1 })
+	register(func() int { return 2 })
+}
+
+func main() {
+	routes()
+}
+`
+
+// closuresModified 只修改了闭包中的代码
+const closuresModified = `package main
+
+func register(f func() int) {
+	println(f())
+}
+
+func routes() {
+	register(func() int { return 1 })
+	register(func() int { return 3 })
+}
+
+func main() {
+	routes()
+}
+`
+
+// closureDiff 返回以 hashMode 计算指纹时 src 相对于 closuresOld 的各个函数的差异
+func closureDiff(t *testing.T, src string, separate bool, hashMode string) map[string]view.DiffType {
+	o := &common.DiffOptions{HashMode: hashMode, SeparateClosures: separate}
+	_, nodes := diffNodes(t, closuresOld, src, o)
+	result := make(map[string]view.DiffType)
+	for name, node := range nodes {
+		result[name] = node.Difference
+	}
+	return result
+}
+
+func TestClosureIdentity(t *testing.T) {
+	for _, mode := range []string{HashSSA, HashNormalized} {
+		diff := closureDiff(t, closuresInserted, true, mode)
+		var inserted, removed, unchanged, closures []string
+		for name, difference := range diff {
+			if !strings.HasPrefix(name, "routes$") {
+				continue
+			}
+			closures = append(closures, name)
+			switch difference {
+			case view.INSERTED:
+				inserted = append(inserted, name)
+			case view.REMOVED, view.RENAMED, view.MOVED:
+				removed = append(removed, name)
+			case view.UNCHANGED:
+				unchanged = append(unchanged, name)
+			}
+		}
+		sort.Strings(closures)
+		// 已有的两个闭包的名称与指纹不受插入的闭包影响
+		if len(closures) != 3 || len(inserted) != 1 || len(removed) != 0 || len(unchanged) != 2 {
+			t.Errorf("%s hash: closures %v, inserted %v, removed or renamed %v, unchanged %v", mode, closures, inserted, removed, unchanged)
+		}
+	}
+}
+
+func TestClosureAttribution(t *testing.T) {
+	diff := closureDiff(t, closuresModified, false, HashSSA)
+	for name := range diff {
+		if strings.Contains(name, "$") {
+			t.Errorf("closure %s should be attributed to its enclosing function", name)
+		}
+	}
+	if diff["routes"] != view.CHANGED {
+		t.Errorf("routes: difference %d, want changed", diff["routes"])
+	}
+	if diff["main"] != view.AFFECTED {
+		t.Errorf("main: difference %d, want affected", diff["main"])
+	}
+
+	// 单独输出时，修改后的闭包指纹改变，与原来的闭包配对后报告为代码改变
+	diff = closureDiff(t, closuresModified, true, HashSSA)
+	changed := 0
+	for name, difference := range diff {
+		if strings.HasPrefix(name, "routes$") {
+			if difference == view.CHANGED {
+				changed++
+			} else if difference != view.UNCHANGED {
+				t.Errorf("closure %s: difference %d", name, difference)
+			}
+		}
+	}
+	if changed != 1 {
+		t.Errorf("separate closures: %v", diff)
+	}
+}
+
+// closuresReplaced 将第二个闭包替换为一个签名相同但无关的闭包
+const closuresReplaced = `package main
+
+func register(f func() int) {
+	println(f())
+}
+
+func routes() {
+	register(func() int { return 1 })
+	register(func() int {
+		total := 0
+		for i := 0; i < 10; i++ {
+			total += i * i
+		}
+		return total
+	})
+}
+
+func main() {
+	routes()
+}
+`
+
+func TestUnrelatedClosures(t *testing.T) {
+	// 签名相同而代码无关的闭包不与删去的闭包配对
+	diff := closureDiff(t, closuresReplaced, true, HashSSA)
+	count := make(map[view.DiffType]int)
+	for name, difference := range diff {
+		if strings.HasPrefix(name, "routes$") {
+			count[difference]++
+		}
+	}
+	if count[view.INSERTED] != 1 || count[view.REMOVED] != 1 || count[view.UNCHANGED] != 1 {
+		t.Errorf("closures: %v", diff)
+	}
+}
+
+const storedOld = `package main
+
+var handlers []func() int
+
+func routes() {
+	handlers = append(handlers, func() int { return 1 })
+}
+
+func main() {
+	routes()
+}
+`
+
+// storedModified 修改了只保存而从未被调用的闭包
+const storedModified = `package main
+
+var handlers []func() int
+
+func routes() {
+	handlers = append(handlers, func() int { return 2 })
+}
+
+func main() {
+	routes()
+}
+`
+
+func TestUncalledClosures(t *testing.T) {
+	// rta 的调用图中没有从未被调用的闭包
+	for _, separate := range []bool{false, true} {
+		o := &common.DiffOptions{HashMode: HashSSA, SeparateClosures: separate, Algo: "rta"}
+		_, nodes := diffNodes(t, storedOld, storedModified, o)
+		changed := make(map[string]bool)
+		for name, node := range nodes {
+			if node.Difference == view.CHANGED {
+				changed[name] = true
+			}
+		}
+		if separate && len(changed) != 1 {
+			t.Errorf("separate closures: changed %v, want the closure", changed)
+		}
+		if !separate && (len(changed) != 1 || !changed["routes"]) {
+			t.Errorf("merged closures: changed %v, want [routes]", changed)
+		}
+	}
+}
+
+const genericsOld = `package main
+
+type Box[T any] struct{ v T }
+
+func (b Box[T]) Get() T { return b.v }
+
+func Map[T, U any](xs []T, f func(T) U) []U {
+	var result []U
+	for _, x := range xs {
+		result = append(result, f(x))
+	}
+	return result
+}
+
+func main() {
+	println(len(Map([]int{1}, func(x int) string { return "" })))
+	println(Box[int]{1}.Get())
+}
+`
+
+const genericsNew = `package main
+
+type Box[T any] struct{ v T }
+
+func (b Box[T]) Get() T { return b.v }
+
+func Map[T, U any](xs []T, f func(T) U) []U {
+	result := make([]U, 0, len(xs))
+	for _, x := range xs {
+		result = append(result, f(x))
+	}
+	return result
+}
+
+func main() {
+	println(len(Map([]string{""}, func(x string) int { return 1 })))
+	println(Box[string]{""}.Get())
+}
+`
+
+func TestGenericInstances(t *testing.T) {
+	o := &common.DiffOptions{HashMode: HashSSA, Algo: "cha"}
+	_, nodes := diffNodes(t, genericsOld, genericsNew, o)
+	// 各个实例合并到泛型函数上
+	for name := range nodes {
+		if strings.Contains(name, "[") && !strings.HasPrefix(name, "(") {
+			t.Errorf("instantiation %s should be collapsed onto its origin", name)
+		}
+	}
+	m, ok := nodes["Map"]
+	if !ok {
+		t.Fatalf("missing Map in %v", nodes)
+	}
+	if m.Difference != view.CHANGED {
+		t.Errorf("Map: difference %d, want changed", m.Difference)
+	}
+	if !equalStrings(m.InstancesAdded, []string{"[string, int]"}) || !equalStrings(m.InstancesRemoved, []string{"[int, string]"}) {
+		t.Errorf("Map: instances added %v, removed %v", m.InstancesAdded, m.InstancesRemoved)
+	}
+	get, ok := nodes["(example.com/fixture.Box[T])Get"]
+	if !ok {
+		t.Fatalf("missing (example.com/fixture.Box[T])Get in %v", nodes)
+	}
+	if get.Difference != view.UNCHANGED {
+		t.Errorf("Get: difference %d, want unchanged", get.Difference)
+	}
+	if !equalStrings(get.InstancesAdded, []string{"[string]"}) || !equalStrings(get.InstancesRemoved, []string{"[int]"}) {
+		t.Errorf("Get: instances added %v, removed %v", get.InstancesAdded, get.InstancesRemoved)
+	}
+}
@@ -0,0 +1,84 @@
+package analyze
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// graphFormatVersion 序列化格式的版本，Node 中参与比较的信息发生变化时需要递增，旧的缓存随之失效
+const graphFormatVersion = 7
+
+// encodedGraph 序列化时使用的调用图
+type encodedGraph struct {
+	Version int
+	Module  string
+	Nodes   []encodedNode
+}
+
+type encodedNode struct {
+	Name      string
+	Kind      string
+	Hash      [32]byte
+	Pos       string
+	Calls     []string
+	Access    map[string]string
+	Facts     funcFacts
+	Type      typeFacts
+	Source    sourceInfo
+	Instances []string
+}
+
+// Encode 将调用图序列化到 w
+func (g *Graph) Encode(w io.Writer) error {
+	e := encodedGraph{Version: graphFormatVersion, Module: g.module}
+	for name, node := range g.nodes {
+		n := encodedNode{Name: name, Kind: node.kind, Access: node.access, Hash: node.hashNum, Pos: node.pos, Facts: node.facts, Type: node.typ, Source: node.source, Instances: node.instances}
+		for callName := range node.callEdge {
+			n.Calls = append(n.Calls, callName)
+		}
+		sort.Strings(n.Calls)
+		e.Nodes = append(e.Nodes, n)
+	}
+	sort.Slice(e.Nodes, func(i, j int) bool { return e.Nodes[i].Name < e.Nodes[j].Name })
+	return gob.NewEncoder(w).Encode(&e)
+}
+
+// DecodeGraph 从 r 中读取 Encode 序列化的调用图，格式版本不一致时返回错误
+func DecodeGraph(r io.Reader) (*Graph, error) {
+	var e encodedGraph
+	if err := gob.NewDecoder(r).Decode(&e); err != nil {
+		return nil, err
+	}
+	if e.Version != graphFormatVersion {
+		return nil, fmt.Errorf("graph format version %d, expected %d", e.Version, graphFormatVersion)
+	}
+	g := newGraphHelper()
+	g.module = e.Module
+	for _, n := range e.Nodes {
+		g.nodes[n.Name] = newNodeHelper()
+		g.nodes[n.Name].name = n.Name
+		g.nodes[n.Name].kind = n.Kind
+		g.nodes[n.Name].hashNum = n.Hash
+		g.nodes[n.Name].pos = n.Pos
+		g.nodes[n.Name].facts = n.Facts
+		g.nodes[n.Name].typ = n.Type
+		g.nodes[n.Name].source = n.Source
+		g.nodes[n.Name].instances = n.Instances
+	}
+	for _, n := range e.Nodes {
+		for _, callName := range n.Calls {
+			callee, ok := g.nodes[callName]
+			if !ok {
+				return nil, fmt.Errorf("graph references unknown function %s", callName)
+			}
+			g.nodes[n.Name].callEdge[callName] = callee
+			if access, ok := n.Access[callName]; ok {
+				g.nodes[n.Name].access[callName] = access
+			}
+			callee.callByEdge[n.Name] = g.nodes[n.Name]
+		}
+	}
+	return g, nil
+}
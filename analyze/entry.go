@@ -0,0 +1,54 @@
+package analyze
+
+import (
+	"strings"
+
+	"github.com/bytecamp2021-calldiff/calldiff/view"
+)
+
+// handlerParams 常见的 HTTP 处理函数的参数类型，包括 net/http 与 gin、echo、fiber 等框架
+var handlerParams = [][]string{
+	{"net/http.ResponseWriter", "*net/http.Request"},
+	{"*github.com/gin-gonic/gin.Context"},
+	{"github.com/labstack/echo/v4.Context"},
+	{"github.com/labstack/echo.Context"},
+	{"*github.com/gofiber/fiber/v2.Ctx"},
+}
+
+// testParams 测试函数的名称前缀与参数类型
+var testParams = map[string]string{
+	"Test":      "*testing.T",
+	"Benchmark": "*testing.B",
+	"Fuzz":      "*testing.F",
+}
+
+// entryKinds 根据函数的签名判断函数可以作为哪些种类的调用链入口，只由名称决定的 main 与 exported 不在其中
+func entryKinds(n *Node) []string {
+	if n.kind != "" {
+		return nil
+	}
+	var kinds []string
+	for _, params := range handlerParams {
+		if equalStrings(n.facts.Params, params) {
+			kinds = append(kinds, view.RootHandlers)
+			break
+		}
+	}
+	if splits := strings.Split(n.name, "#"); len(splits) > 2 && isTestFunc(splits[2], &n.facts) {
+		kinds = append(kinds, view.RootTests)
+	}
+	return kinds
+}
+
+// isTestFunc 判断函数是否为 go test 运行的测试、基准测试、模糊测试或示例函数
+func isTestFunc(name string, facts *funcFacts) bool {
+	if facts.Recv != "" || len(facts.Results) != 0 {
+		return false
+	}
+	for prefix, param := range testParams {
+		if strings.HasPrefix(name, prefix) && equalStrings(facts.Params, []string{param}) {
+			return true
+		}
+	}
+	return strings.HasPrefix(name, "Example") && len(facts.Params) == 0
+}
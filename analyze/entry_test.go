@@ -0,0 +1,33 @@
+package analyze
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bytecamp2021-calldiff/calldiff/view"
+)
+
+func TestEntryKinds(t *testing.T) {
+	cases := []struct {
+		name  string
+		facts funcFacts
+		want  []string
+	}{
+		{"ServeHTTP", funcFacts{Recv: "*example.com/m.Server", Params: []string{"net/http.ResponseWriter", "*net/http.Request"}}, []string{view.RootHandlers}},
+		{"listUsers", funcFacts{Params: []string{"*github.com/gin-gonic/gin.Context"}}, []string{view.RootHandlers}},
+		{"TestParse", funcFacts{Params: []string{"*testing.T"}}, []string{view.RootTests}},
+		{"BenchmarkParse", funcFacts{Params: []string{"*testing.B"}}, []string{view.RootTests}},
+		{"ExampleParse", funcFacts{}, []string{view.RootTests}},
+		{"TestMain", funcFacts{Params: []string{"*testing.M"}}, nil},
+		{"Testify", funcFacts{Params: []string{"*testing.T"}, Results: []string{"error"}}, nil},
+		{"Parse", funcFacts{Params: []string{"string"}}, nil},
+	}
+	for _, c := range cases {
+		n := newNodeHelper()
+		n.name = "example.com/m#m#" + c.name + "#"
+		n.facts = c.facts
+		if got := entryKinds(n); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: %v, want %v", c.name, got, c.want)
+		}
+	}
+}
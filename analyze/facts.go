@@ -0,0 +1,150 @@
+package analyze
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// funcFacts 函数的结构化信息，用于说明代码改变的函数具体改变了什么
+type funcFacts struct {
+	Recv           string   // 接收者类型
+	Params         []string // 参数类型
+	Results        []string // 返回值类型
+	Variadic       bool
+	Consts         []string // 引用的常量
+	GlobalsRead    []string // 读取的全局变量
+	GlobalsWritten []string // 写入的全局变量
+	Panics         int      // panic 的个数
+	Loops          int      // 循环的个数
+	ControlFlow    [32]byte // 控制流图的形状
+}
+
+// getFuncFacts 从 SSA 中收集函数的结构化信息，循环的个数取自语法树，没有源代码时取控制流图中回边的个数
+func getFuncFacts(f *ssa.Function, r *sourceReader) funcFacts {
+	var facts funcFacts
+	sig := f.Signature
+	if recv := sig.Recv(); recv != nil {
+		facts.Recv = types.TypeString(recv.Type(), nil)
+	}
+	for i := 0; i < sig.Params().Len(); i++ {
+		facts.Params = append(facts.Params, types.TypeString(sig.Params().At(i).Type(), nil))
+	}
+	for i := 0; i < sig.Results().Len(); i++ {
+		facts.Results = append(facts.Results, types.TypeString(sig.Results().At(i).Type(), nil))
+	}
+	facts.Variadic = sig.Variadic()
+
+	consts := make(map[string]bool)
+	globalsRead := make(map[string]bool)
+	globalsWritten := make(map[string]bool)
+	backEdges := 0
+	for _, block := range f.Blocks {
+		for _, succ := range block.Succs {
+			if succ.Dominates(block) {
+				backEdges++
+			}
+		}
+		for _, instr := range block.Instrs {
+			if _, ok := instr.(*ssa.Panic); ok {
+				facts.Panics++
+			}
+			for _, op := range instr.Operands(nil) {
+				switch v := (*op).(type) {
+				case *ssa.Const:
+					consts[v.String()] = true
+				case *ssa.Global:
+					if store, ok := instr.(*ssa.Store); ok && store.Addr == v {
+						globalsWritten[v.String()] = true
+					} else {
+						globalsRead[v.String()] = true
+					}
+				}
+			}
+		}
+	}
+	facts.Consts = sortedKeys(consts)
+	facts.GlobalsRead = sortedKeys(globalsRead)
+	facts.GlobalsWritten = sortedKeys(globalsWritten)
+	facts.Loops = backEdges
+	if src, ok := r.funcSource(f); ok {
+		if syntax, err := parseFunc(src); err == nil {
+			facts.Loops = countLoops(syntax)
+		}
+	}
+	facts.ControlFlow = controlFlowHash(f)
+	return facts
+}
+
+// merge 将闭包 other 中引用的常量与全局变量、panic、循环与控制流合并到外层函数中，签名不变
+func (f *funcFacts) merge(other *funcFacts) {
+	f.Consts = mergeStrings(f.Consts, other.Consts)
+	f.GlobalsRead = mergeStrings(f.GlobalsRead, other.GlobalsRead)
+	f.GlobalsWritten = mergeStrings(f.GlobalsWritten, other.GlobalsWritten)
+	f.Panics += other.Panics
+	f.Loops += other.Loops
+	h := sha256.New()
+	h.Write(f.ControlFlow[:])
+	h.Write(other.ControlFlow[:])
+	copy(f.ControlFlow[:], h.Sum(nil))
+}
+
+func mergeStrings(a []string, b []string) []string {
+	set := make(map[string]bool)
+	for _, s := range a {
+		set[s] = true
+	}
+	for _, s := range b {
+		set[s] = true
+	}
+	return sortedKeys(set)
+}
+
+// countLoops 统计函数中 for 与 range 语句的个数，不包括其中的函数字面量（闭包是单独的函数）
+func countLoops(syntax ast.Node) int {
+	loops := 0
+	ast.Inspect(syntax, func(node ast.Node) bool {
+		switch node.(type) {
+		case *ast.ForStmt, *ast.RangeStmt:
+			loops++
+		case *ast.FuncLit:
+			return node == syntax
+		}
+		return true
+	})
+	return loops
+}
+
+// controlFlowHash 计算控制流图的形状，只记录基本块按规范顺序排列后的后继与结尾指令的种类
+func controlFlowHash(f *ssa.Function) [32]byte {
+	blocks := canonicalBlocks(f)
+	index := make(map[*ssa.BasicBlock]int)
+	for i, block := range blocks {
+		index[block] = i
+	}
+	var b bytes.Buffer
+	for _, block := range blocks {
+		if len(block.Instrs) > 0 {
+			fmt.Fprintf(&b, "%T", block.Instrs[len(block.Instrs)-1])
+		}
+		for _, succ := range block.Succs {
+			fmt.Fprintf(&b, " %d", index[succ])
+		}
+		b.WriteString("\n")
+	}
+	return sha256.Sum256(b.Bytes())
+}
+
+func sortedKeys(set map[string]bool) []string {
+	var result []string
+	for key := range set {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
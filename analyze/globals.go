@@ -0,0 +1,270 @@
+package analyze
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+
+	"github.com/bytecamp2021-calldiff/calldiff/view"
+)
+
+// ConstUses 源代码中对包级常量的引用，map[引用处的位置]常量。
+// SSA 中常量已被替换为其值，无法从 SSA 中找到函数引用的常量，需要借助类型检查的结果
+type ConstUses map[token.Pos]*types.Const
+
+// Add 从类型检查的结果中收集对包级常量的引用
+func (u ConstUses) Add(info *types.Info) {
+	if info == nil {
+		return
+	}
+	for ident, obj := range info.Uses {
+		if c, ok := obj.(*types.Const); ok && c.Pkg() != nil && c.Parent() == c.Pkg().Scope() {
+			u[ident.Pos()] = c
+		}
+	}
+}
+
+// positions 返回引用处的位置，按先后排列
+func (u ConstUses) positions() []token.Pos {
+	result := make([]token.Pos, 0, len(u))
+	for pos := range u {
+		result = append(result, pos)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// constRefs 返回函数的源代码范围内引用的包级常量，consts 为 uses 中按先后排列的位置
+func constRefs(f *ssa.Function, consts []token.Pos, uses ConstUses) []*types.Const {
+	syntax := f.Syntax()
+	if syntax == nil || f.Synthetic != "" {
+		return nil
+	}
+	var result []*types.Const
+	i := sort.Search(len(consts), func(i int) bool { return consts[i] >= syntax.Pos() })
+	for ; i < len(consts) && consts[i] < syntax.End(); i++ {
+		result = append(result, uses[consts[i]])
+	}
+	return result
+}
+
+// globalsHasher 包装 hasher，使全局变量与常量的改变不算作函数代码的改变，而是通过函数对它们的读取传播：
+// 包的初始化函数是合成的，只包含全局变量的初始化与对 init 函数的调用，其指纹只由名称决定；
+// ssa 与 normalized 指纹中常量已被替换为其值（可能已与其他常量折叠），引用了包级常量的函数
+// 改为使用不记录常量值的规范化 SSA 指纹与语法树指纹，语法树中记录的是常量的名称
+func globalsHasher(hasher funcHasher, mode string, uses ConstUses) funcHasher {
+	consts := uses.positions()
+	r := newSourceReader()
+	return func(f *ssa.Function) [32]byte {
+		if f.Synthetic == "package initializer" {
+			return sha256.Sum256([]byte(f.String()))
+		}
+		if mode != HashSSA && mode != HashNormalized && mode != "" {
+			return hasher(f)
+		}
+		if len(constRefs(f, consts, uses)) == 0 {
+			return hasher(f)
+		}
+		src, ok := r.funcSource(f)
+		if !ok {
+			return hasher(f)
+		}
+		syntax, err := parseFunc(src)
+		if err != nil {
+			return hasher(f)
+		}
+		normalized, tree := normalizedHash(f, false), astHash(syntax)
+		return sha256.Sum256(append(normalized[:], tree[:]...))
+	}
+}
+
+// globalReader 为函数读写的全局变量、引用的常量与用到的类型生成调用图中的节点
+type globalReader struct {
+	r      *sourceReader
+	root   string
+	module string
+	consts []token.Pos // ConstUses 中的位置，按先后排列
+	uses   ConstUses
+}
+
+func newGlobalReader(r *sourceReader, root string, module string, uses ConstUses) *globalReader {
+	return &globalReader{r: r, root: root, module: module, consts: uses.positions(), uses: uses}
+}
+
+// refs 返回函数读写的全局变量、引用的常量与用到的类型及访问方式，同时读取与写入的全局变量记为读取
+func (gr *globalReader) refs(f *ssa.Function) map[types.Object]string {
+	refs := make(map[types.Object]string)
+	typeRefs := make(map[*types.TypeName]bool)
+	namedTypes(f.Signature, typeRefs)
+	if recv := f.Signature.Recv(); recv != nil {
+		namedTypes(recv.Type(), typeRefs)
+	}
+	for _, block := range f.Blocks {
+		for _, instr := range block.Instrs {
+			if v, ok := instr.(ssa.Value); ok {
+				namedTypes(v.Type(), typeRefs)
+			}
+			for _, op := range instr.Operands(nil) {
+				if *op != nil {
+					namedTypes((*op).Type(), typeRefs)
+				}
+				global, ok := (*op).(*ssa.Global)
+				if !ok || global.Object() == nil {
+					continue // 如 init$guard 等合成的全局变量
+				}
+				if store, ok := instr.(*ssa.Store); ok && store.Addr == global {
+					if _, ok := refs[global.Object()]; !ok {
+						refs[global.Object()] = view.Writes
+					}
+				} else {
+					refs[global.Object()] = view.Reads
+				}
+			}
+		}
+	}
+	// 函数的源代码范围内引用的常量
+	for _, c := range constRefs(f, gr.consts, gr.uses) {
+		refs[c] = view.Reads
+	}
+	for typeName := range typeRefs {
+		refs[typeName] = view.Uses
+	}
+	return refs
+}
+
+// node 返回全局变量、常量或类型在 g 中的节点，不存在时创建。
+// 模块以外（如标准库）的全局变量、常量与类型不会在两个提交之间改变，返回 nil
+func (gr *globalReader) node(g *Graph, obj types.Object, fset *token.FileSet) *Node {
+	if obj.Pkg() == nil || obj.Parent() != obj.Pkg().Scope() {
+		return nil // 如 error 等预声明的类型、函数中声明的类型
+	}
+	path := obj.Pkg().Path()
+	if gr.module != "" && path != gr.module && !strings.HasPrefix(path, gr.module+"/") {
+		return nil
+	}
+	name := fmt.Sprintf("%v#%v#%v#", path, obj.Pkg().Name(), obj.Name())
+	if node, ok := g.nodes[name]; ok {
+		return node
+	}
+	node := newNodeHelper()
+	node.name = name
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", types.TypeString(obj.Type(), nil))
+	position := fset.Position(obj.Pos())
+	spec, index, extent := gr.declSpec(position)
+	var named *types.Named
+	switch obj := obj.(type) {
+	case *types.TypeName:
+		// 类型按字段、标签与方法集比较，与方法的实现无关
+		var ok bool
+		if named, ok = obj.Type().(*types.Named); !ok {
+			return nil // 类型别名以其实际类型表示
+		}
+		node.kind = view.NamedType
+		node.typ = getTypeFacts(named)
+		node.hashNum = node.typ.hash()
+	case *types.Const:
+		// 常量按值比较，与 iota 等写法无关
+		node.kind = view.GlobalConst
+		h.Write([]byte(obj.Val().ExactString()))
+		copy(node.hashNum[:], h.Sum(nil))
+	default:
+		// 全局变量按初始化表达式的语法树比较，与格式和注释无关
+		node.kind = view.GlobalVar
+		if spec, ok := spec.(*ast.ValueSpec); ok && len(spec.Values) == len(spec.Names) {
+			hash := astHash(spec.Values[index])
+			h.Write(hash[:])
+		} else if ok {
+			for _, value := range spec.Values { // 如 var a, b = f()
+				hash := astHash(value)
+				h.Write(hash[:])
+			}
+		}
+		copy(node.hashNum[:], h.Sum(nil))
+	}
+	if position.IsValid() {
+		node.pos = fmt.Sprintf("%s:%d", relPath(position.Filename, gr.root), position.Line)
+	}
+	if extent != nil {
+		start, end := gr.r.fset.Position(extent.Pos()), gr.r.fset.Position(extent.End())
+		node.source = sourceInfo{
+			File:      relPath(start.Filename, gr.root),
+			StartLine: start.Line,
+			EndLine:   end.Line,
+			Text:      string(gr.r.files[start.Filename][start.Offset:end.Offset]),
+		}
+	}
+	g.nodes[name] = node
+	// 类型的字段、嵌入的接口或底层类型中用到的其他类型改变时，该类型同样受到影响
+	if named != nil {
+		refs := make(map[*types.TypeName]bool)
+		namedTypes(named.Underlying(), refs)
+		if iface, ok := named.Underlying().(*types.Interface); ok {
+			for i := 0; i < iface.NumEmbeddeds(); i++ {
+				namedTypes(iface.EmbeddedType(i), refs)
+			}
+		}
+		for ref := range refs {
+			if ref == obj {
+				continue
+			}
+			if refNode := gr.node(g, ref, fset); refNode != nil {
+				addAccess(node, refNode, view.Uses)
+			}
+		}
+	}
+	return node
+}
+
+// declSpec 返回 position 处声明的全局变量、常量或类型所在的 ValueSpec 或 TypeSpec、在其中的下标，以及作为源代码的范围：
+// 单独的声明为整个声明，括号中的声明为其所在的一项
+func (gr *globalReader) declSpec(position token.Position) (ast.Spec, int, ast.Node) {
+	if !position.IsValid() {
+		return nil, 0, nil
+	}
+	file := gr.r.parseFile(position.Filename)
+	if file == nil {
+		return nil, 0, nil
+	}
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			var names []*ast.Ident
+			switch spec := spec.(type) {
+			case *ast.ValueSpec:
+				names = spec.Names
+			case *ast.TypeSpec:
+				names = []*ast.Ident{spec.Name}
+			}
+			for i, name := range names {
+				if gr.r.fset.Position(name.Pos()).Offset != position.Offset {
+					continue
+				}
+				if gen.Lparen.IsValid() {
+					return spec, i, spec
+				}
+				return spec, i, gen
+			}
+		}
+	}
+	return nil, 0, nil
+}
+
+// addAccess 添加 caller 对全局变量、常量或类型 global 的访问，读取优先于写入
+func addAccess(caller *Node, global *Node, access string) {
+	if caller.access[global.name] == view.Reads {
+		return
+	}
+	caller.callEdge[global.name] = global
+	caller.access[global.name] = access
+	global.callByEdge[caller.name] = caller
+}
@@ -0,0 +1,136 @@
+package analyze
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+	"github.com/bytecamp2021-calldiff/calldiff/view"
+)
+
+const globalsOld = `package main
+
+const Limit = 3
+
+var Timeout = 5
+
+func ReadLimit() int {
+	return Limit * 2
+}
+
+func UseTimeout() int {
+	return Timeout
+}
+
+func SetTimeout() {
+	Timeout = 10
+}
+
+func main() {
+	println(ReadLimit(), UseTimeout())
+	SetTimeout()
+}
+`
+
+// globalsNew 改变了常量的值与全局变量的初始值，函数的代码不变
+const globalsNew = `package main
+
+const Limit = 4
+
+var Timeout = 5 + 1
+
+func ReadLimit() int {
+	return Limit * 2
+}
+
+func UseTimeout() int {
+	return Timeout
+}
+
+func SetTimeout() {
+	Timeout = 10
+}
+
+func main() {
+	println(ReadLimit(), UseTimeout())
+	SetTimeout()
+}
+`
+
+func TestGlobalChanges(t *testing.T) {
+	// SSA 中常量被替换为其值，各种指纹都不应把常量或全局变量的改变算作读取者的代码改变
+	for _, mode := range []string{HashSSA, HashNormalized, HashAST} {
+		testGlobalChanges(t, mode)
+	}
+}
+
+func testGlobalChanges(t *testing.T, mode string) {
+	o := &common.DiffOptions{HashMode: mode, PrintPrivate: true, Pkg: "main"}
+	diffGraph, nodes := diffNodes(t, globalsOld, globalsNew, o)
+	for name, kind := range map[string]string{"Limit": view.GlobalConst, "Timeout": view.GlobalVar} {
+		node, ok := nodes[name]
+		if !ok {
+			t.Fatalf("%s hash: missing %s in %v", mode, name, nodes)
+		}
+		if node.Kind != kind || node.Difference != view.CHANGED || node.Reason != view.ValueChanged {
+			t.Errorf("%s hash: %s: kind %q, difference %d, reason %q", mode, name, node.Kind, node.Difference, node.Reason)
+		}
+	}
+	// 读取者受影响，只写入的函数与包的初始化函数不受影响
+	for name, cause := range map[string]string{"ReadLimit": "Limit", "UseTimeout": "Timeout"} {
+		node := nodes[name]
+		if node.Difference != view.AFFECTED || node.Distance != 1 || node.RootCauses[nodes[cause].Name] != 1 {
+			t.Errorf("%s hash: %s: difference %d, distance %d, root causes %v", mode, name, node.Difference, node.Distance, node.RootCauses)
+		}
+	}
+	for _, name := range []string{"SetTimeout", "init"} {
+		if nodes[name].Difference != view.UNCHANGED {
+			t.Errorf("%s hash: %s: difference %d, want unchanged", mode, name, nodes[name].Difference)
+		}
+	}
+	if edge := nodes["SetTimeout"].CallEdge[nodes["Timeout"].Name]; edge == nil || edge.Access != view.Writes {
+		t.Errorf("%s hash: SetTimeout should write Timeout: %v", mode, edge)
+	}
+
+	output := view.NewOutput(diffGraph, o)
+	affectedBy := make(map[string][]string)
+	for _, modified := range output.ChangeList.Modified {
+		for _, call := range modified.AffectedCall {
+			affectedBy[modified.Name] = append(affectedBy[modified.Name], call.AffectedBy...)
+		}
+	}
+	if !equalStrings(affectedBy["main.ReadLimit"], []string{"main.Limit"}) || !equalStrings(affectedBy["main.UseTimeout"], []string{"main.Timeout"}) {
+		t.Errorf("%s hash: affected by %v", mode, affectedBy)
+	}
+	if len(output.ChangeList.Globals) != 2 {
+		t.Errorf("%s hash: globals: %+v", mode, output.ChangeList.Globals)
+	}
+}
+
+// 引用了常量的函数中其他字面量的改变仍是代码改变
+func TestConstReaderChanges(t *testing.T) {
+	src := strings.Replace(globalsOld, "return Limit * 2", "return Limit * 3", 1)
+	for _, mode := range []string{HashSSA, HashNormalized} {
+		o := &common.DiffOptions{HashMode: mode}
+		if _, nodes := diffNodes(t, globalsOld, src, o); nodes["ReadLimit"].Difference != view.CHANGED {
+			t.Errorf("%s hash: ReadLimit: difference %d, want changed", mode, nodes["ReadLimit"].Difference)
+		}
+	}
+}
+
+func TestGlobalFormatting(t *testing.T) {
+	// 只改变格式与注释时全局变量不变
+	src := `package main
+
+const Limit = 3
+
+var Timeout = /* seconds */ 5
+` + globalsOld[len("package main\n\nconst Limit = 3\n\nvar Timeout = 5\n"):]
+	o := &common.DiffOptions{HashMode: HashSSA}
+	_, nodes := diffNodes(t, globalsOld, src, o)
+	for name, node := range nodes {
+		if node.Difference != view.UNCHANGED {
+			t.Errorf("%s: difference %d, want unchanged", name, node.Difference)
+		}
+	}
+}
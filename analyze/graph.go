@@ -5,24 +5,80 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"go/types"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 
 	"golang.org/x/tools/go/callgraph"
 	"golang.org/x/tools/go/ssa"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
 )
 
 // Node 函数调用图中的函数节点
 type Node struct {
-	name       string           //函数的名称
-	hashNum    [32]byte         //代码部分求hash过后的值,在两图的交集中0表示两图hashNum一样，否则不一样
-	isChanged  bool             //判断有无改变
-	callByEdge map[string]*Node //指向所有被调用的函数（即a调用b，b向a连边）
-	callEdge   map[string]*Node //所有调用边
+	name            string            //函数的名称
+	kind            string            //节点的种类：函数为空，全局变量为 view.GlobalVar，常量为 view.GlobalConst，类型为 view.NamedType
+	hashNum         [32]byte          //代码部分求hash过后的值,在两图的交集中0表示两图hashNum一样，否则不一样
+	pos             string            //函数定义的位置，形如 file:line，file 为相对于快照根目录的路径
+	isChanged       bool              //判断有无改变
+	isStructChanged bool              //调用的函数集合有无改变
+	facts           funcFacts         //函数的结构化信息
+	typ             typeFacts         //类型的结构化信息，只用于类型节点
+	source          sourceInfo        //函数的源代码，合成的函数为空
+	instances       []string          //泛型函数在调用图中出现的实例的类型实参，如 [int, string]
+	callByEdge      map[string]*Node  //指向所有被调用的函数（即a调用b，b向a连边）
+	callEdge        map[string]*Node  //所有调用边
+	access          map[string]string //callEdge 中对全局变量、常量与类型的访问方式，view.Reads、view.Writes 或 view.Uses
 }
 
 // Graph 函数调用图
 type Graph struct {
-	nodes map[string]*Node
+	nodes  map[string]*Node
+	module string //模块路径
+}
+
+// NewGraph 将 cg 转换为用于比较的调用图，root 为快照的根目录，module 为模块路径，consts 为源代码中对常量的引用。
+// o.HashMode 为函数指纹的计算方式；o.SeparateClosures 为 false 时闭包合并到其最外层的函数中
+func NewGraph(cg *callgraph.Graph, root string, module string, o *common.DiffOptions, consts ConstUses) (*Graph, error) {
+	hasher, err := newFuncHasher(o.HashMode)
+	if err != nil {
+		return nil, err
+	}
+	g := callGraph2graph(cg, root, module, globalsHasher(hasher, o.HashMode, consts), o.SeparateClosures, consts)
+	g.module = module
+	return g, nil
+}
+
+// Module 返回调用图的模块路径
+func (g *Graph) Module() string {
+	return g.module
+}
+
+// funcPos 返回函数定义的位置，快照中的文件使用相对路径
+func funcPos(f *ssa.Function, root string) string {
+	if f.Prog == nil || !f.Pos().IsValid() {
+		return ""
+	}
+	position := f.Prog.Fset.Position(f.Pos())
+	return fmt.Sprintf("%s:%d", relPath(position.Filename, root), position.Line)
+}
+
+// relPath 返回快照中的文件相对于快照根目录的路径，快照以外的文件保持原样
+func relPath(filename string, root string) string {
+	if rel, err := filepath.Rel(root, filename); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return filename
+}
+
+// sourceInfo 函数的源代码与所在的行
+type sourceInfo struct {
+	File      string // 相对于快照根目录的路径
+	StartLine int
+	EndLine   int
+	Text      string
 }
 
 func newGraphHelper() *Graph {
@@ -35,6 +91,7 @@ func newNodeHelper() *Node {
 	var n = new(Node)
 	n.callByEdge = make(map[string]*Node)
 	n.callEdge = make(map[string]*Node)
+	n.access = make(map[string]string)
 	return n
 }
 
@@ -42,6 +99,24 @@ func isEqual(n1 *Node, n2 *Node) bool {
 	return n1.hashNum == n2.hashNum
 }
 
+// isCallEqual 判断两个版本中的函数调用的函数集合是否一致，对全局变量与常量的访问不算作调用
+func isCallEqual(n1 *Node, n2 *Node) bool {
+	return containsCalls(n1, n2) && containsCalls(n2, n1)
+}
+
+// containsCalls 判断 n1 调用的函数 n2 是否都调用了
+func containsCalls(n1 *Node, n2 *Node) bool {
+	for callName := range n1.callEdge {
+		if _, ok := n1.access[callName]; ok {
+			continue
+		}
+		if _, ok := n2.callEdge[callName]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
 //求图的交，方便求强连通
 func intersectGraph(oldGraph *Graph, newGraph *Graph) *Graph {
 	var interGraph = newGraphHelper()
@@ -53,6 +128,9 @@ func intersectGraph(oldGraph *Graph, newGraph *Graph) *Graph {
 			if n2.hashNum != n1.hashNum {
 				interGraph.nodes[key].isChanged = true //两hash值做差判断是否发生改变
 			}
+			if !isCallEqual(n1, n2) {
+				interGraph.nodes[key].isStructChanged = true //新增或删去了调用
+			}
 		}
 	}
 	//把边加上
@@ -84,6 +162,12 @@ func func2str(ssaFunction *ssa.Function) string {
 	return result
 }
 
+// fieldIndex SSA 文本中字段访问的下标，如 &t0.Name [#1] 中的 [#1]
+var fieldIndex = regexp.MustCompile(` \[#\d+\]`)
+
+// getFuncHash 计算函数的 SSA 文本的指纹。
+// 闭包的名称 parent$N 与其在源代码中的顺序有关，因此 func 一行只记录签名，
+// 函数体中创建的闭包只记录其在本函数中的序号，与本函数自身的名称无关
 func getFuncHash(ssaFunction *ssa.Function) [32]byte {
 	var b bytes.Buffer
 	ssa.WriteFunction(&b, ssaFunction)
@@ -94,32 +178,142 @@ func getFuncHash(ssaFunction *ssa.Function) [32]byte {
 		if !state {
 			if !strings.HasPrefix(line, "#") {
 				state = true
+				resultString += types.TypeString(ssaFunction.Signature, nil)
+				continue
 			}
 		}
 		if state {
 			resultString += line
 		}
 	}
+	// 字段按名称记录，去掉字段的下标，调整字段顺序不改变指纹
+	resultString = fieldIndex.ReplaceAllString(resultString, "")
+	// 先替换较长的名称，避免 f$1 替换 f$10 的前缀
+	anons := append([]*ssa.Function(nil), ssaFunction.AnonFuncs...)
+	sort.Slice(anons, func(i, j int) bool { return len(anons[i].Name()) > len(anons[j].Name()) })
+	for _, anon := range anons {
+		resultString = strings.ReplaceAll(resultString, anon.Name(), "closure"+strings.TrimPrefix(anon.Name(), ssaFunction.Name()))
+	}
 	return sha256.Sum256([]byte(resultString))
 }
 
-func callGraph2graph(cg *callgraph.Graph) *Graph {
+func callGraph2graph(cg *callgraph.Graph, root string, module string, hasher funcHasher, separateClosures bool, consts ConstUses) *Graph {
 	var g = newGraphHelper()
-	nodeMap := make(map[*callgraph.Node]struct{})
+	r := newSourceReader()
+	namer := newFuncNamer()
+	// owners 为每个函数在图中对应的函数：泛型函数的实例对应其泛型函数，默认闭包归属于其最外层的函数
+	owners := make(map[*ssa.Function]*ssa.Function)
+	present := make(map[*ssa.Function]bool)
+	for key := range cg.Nodes {
+		// cha、static 的根节点没有对应函数
+		if key == nil {
+			continue
+		}
+		origin := genericOrigin(key)
+		if origin.Pkg == nil {
+			continue
+		}
+		owners[key] = origin
+		present[origin] = true
+	}
+	// cha、rta、vta 的调用图中没有从未被调用的闭包，如只保存在变量中的闭包，它们同样是外层函数的代码
+	var missing []*ssa.Function
+	for f := range present {
+		if f.Parent() == nil {
+			for _, anon := range nestedClosures(f) {
+				if !present[anon] {
+					missing = append(missing, anon)
+				}
+			}
+		}
+	}
+	for _, anon := range missing {
+		owners[anon] = anon
+		present[anon] = true
+	}
+	if !separateClosures {
+		for key, owner := range owners {
+			if top := topLevel(owner); top != owner && present[top] {
+				owners[key] = top
+			}
+		}
+	}
+	newNode := func(f *ssa.Function) *Node {
+		node := newNodeHelper()
+		node.name = namer.name(f)
+		node.hashNum = hasher(f)
+		node.facts = getFuncFacts(f, r)
+		node.source = r.funcSourceInfo(f, root)
+		node.pos = funcPos(f, root)
+		return node
+	}
+	closures := make(map[string][]*Node)
+	created := make(map[*ssa.Function]bool)
+	for key, owner := range owners {
+		f := genericOrigin(key)
+		if created[f] {
+			continue
+		}
+		created[f] = true
+		node := newNode(f)
+		if owner != f {
+			ownerName := namer.name(owner)
+			closures[ownerName] = append(closures[ownerName], node)
+			continue
+		}
+		g.nodes[node.name] = node
+	}
+	mergeClosures(g, closures)
+	// 记录泛型函数在调用图中出现的实例
+	instances := make(map[string]map[string]bool)
+	for key, owner := range owners {
+		if key.Origin() == nil {
+			continue
+		}
+		name := namer.name(owner)
+		if instances[name] == nil {
+			instances[name] = make(map[string]bool)
+		}
+		instances[name][typeArgs(key)] = true
+	}
+	for name, set := range instances {
+		g.nodes[name].instances = sortedKeys(set)
+	}
 	for key, value := range cg.Nodes {
-		nodeMap[value] = struct{}{}
-		s := func2str(key)
-		g.nodes[s] = newNodeHelper()
-		g.nodes[s].name = s
-		g.nodes[s].hashNum = getFuncHash(key)
-	}
-	for node := range nodeMap {
-		for _, edge := range node.Out {
-			if _, ok := nodeMap[edge.Callee]; ok {
-				calleeName := func2str(edge.Callee.Func)
-				callerName := func2str(edge.Caller.Func)
-				g.nodes[callerName].callEdge[calleeName] = g.nodes[calleeName]
-				g.nodes[calleeName].callByEdge[callerName] = g.nodes[callerName]
+		if _, ok := owners[key]; !ok {
+			continue
+		}
+		callerName := namer.name(owners[key])
+		for _, edge := range value.Out {
+			callee, ok := owners[edge.Callee.Func]
+			if !ok {
+				continue
+			}
+			calleeName := namer.name(callee)
+			if calleeName == callerName {
+				continue // 闭包与其外层函数之间、泛型函数的实例之间的调用
+			}
+			g.nodes[callerName].callEdge[calleeName] = g.nodes[calleeName]
+			g.nodes[calleeName].callByEdge[callerName] = g.nodes[callerName]
+		}
+	}
+	// 添加函数读写的全局变量、引用的常量与用到的类型，闭包的访问同样归属于其在图中对应的函数
+	globals := newGlobalReader(r, root, module, consts)
+	done := make(map[*ssa.Function]bool)
+	for _, owner := range owners {
+		if done[owner] {
+			continue
+		}
+		done[owner] = true
+		funcs := []*ssa.Function{owner}
+		if !separateClosures && owner.Parent() == nil {
+			funcs = append(funcs, nestedClosures(owner)...)
+		}
+		for _, f := range funcs {
+			for obj, access := range globals.refs(f) {
+				if global := globals.node(g, obj, f.Prog.Fset); global != nil {
+					addAccess(g.nodes[namer.name(owner)], global, access)
+				}
 			}
 		}
 	}
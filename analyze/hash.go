@@ -0,0 +1,363 @@
+package analyze
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// 支持的函数指纹
+const (
+	HashSSA        = "ssa"        // SSA 形式的文本，寄存器编号、基本块顺序与局部变量名的改变都会导致不一致
+	HashNormalized = "normalized" // 规范化后的 SSA：局部值按规范顺序重新命名，只保留指令、类型、常量与被调用函数
+	HashAST        = "ast"        // 函数的语法树，忽略格式与注释
+	HashSource     = "source"     // 函数的源代码文本
+)
+
+// HashModes 支持的函数指纹
+var HashModes = []string{HashSSA, HashNormalized, HashAST, HashSource}
+
+// funcHasher 计算函数的指纹
+type funcHasher func(f *ssa.Function) [32]byte
+
+func newFuncHasher(mode string) (funcHasher, error) {
+	switch mode {
+	case HashSSA, "":
+		return getFuncHash, nil
+	case HashNormalized:
+		return getNormalizedHash, nil
+	case HashAST:
+		return newASTHasher(), nil
+	case HashSource:
+		return newSourceHasher(), nil
+	}
+	return nil, fmt.Errorf("unsupported hash mode %q, supported are %s", mode, strings.Join(HashModes, ", "))
+}
+
+// getNormalizedHash 计算规范化的 SSA 指纹：
+// 基本块按从入口开始的深度优先顺序编号，参数、自由变量与指令定义的值按出现顺序重新命名，
+// 指令只记录种类、类型、运算符与操作数，操作数中的常量记录其值，函数与全局变量记录其完整名称
+func getNormalizedHash(f *ssa.Function) [32]byte {
+	return normalizedHash(f, true)
+}
+
+// normalizedHash 计算规范化的 SSA 指纹，constValues 为 false 时操作数中的常量只记录其类型
+func normalizedHash(f *ssa.Function, constValues bool) [32]byte {
+	var b bytes.Buffer
+	for _, param := range f.Params {
+		fmt.Fprintf(&b, "param %s\n", types.TypeString(param.Type(), nil))
+	}
+	results := f.Signature.Results()
+	for i := 0; i < results.Len(); i++ {
+		fmt.Fprintf(&b, "result %s\n", types.TypeString(results.At(i).Type(), nil))
+	}
+	if f.Signature.Variadic() {
+		b.WriteString("variadic\n")
+	}
+
+	blocks := canonicalBlocks(f)
+	n := &normalizer{
+		names:       make(map[ssa.Value]string),
+		blocks:      make(map[*ssa.BasicBlock]int),
+		constValues: constValues,
+	}
+	for i, param := range f.Params {
+		n.names[param] = fmt.Sprintf("p%d", i)
+	}
+	for i, fv := range f.FreeVars {
+		n.names[fv] = fmt.Sprintf("fv%d", i)
+	}
+	// 先为所有的值命名，phi 可能引用在之后的基本块中定义的值
+	for i, block := range blocks {
+		n.blocks[block] = i
+		for _, instr := range block.Instrs {
+			if v, ok := instr.(ssa.Value); ok {
+				n.names[v] = fmt.Sprintf("v%d", len(n.names))
+			}
+		}
+	}
+	for i, block := range blocks {
+		fmt.Fprintf(&b, "block %d\n", i)
+		for _, instr := range block.Instrs {
+			n.writeInstr(&b, instr)
+		}
+		b.WriteString("succs")
+		for _, succ := range block.Succs {
+			fmt.Fprintf(&b, " %d", n.blocks[succ])
+		}
+		b.WriteString("\n")
+	}
+	return sha256.Sum256(b.Bytes())
+}
+
+// canonicalBlocks 按从入口开始的深度优先先序排列基本块，recover 块排在最后
+func canonicalBlocks(f *ssa.Function) []*ssa.BasicBlock {
+	var result []*ssa.BasicBlock
+	visited := make(map[*ssa.BasicBlock]bool)
+	var visit func(block *ssa.BasicBlock)
+	visit = func(block *ssa.BasicBlock) {
+		if visited[block] {
+			return
+		}
+		visited[block] = true
+		result = append(result, block)
+		for _, succ := range block.Succs {
+			visit(succ)
+		}
+	}
+	if len(f.Blocks) > 0 {
+		visit(f.Blocks[0])
+	}
+	if f.Recover != nil {
+		visit(f.Recover)
+	}
+	for _, block := range f.Blocks {
+		visit(block)
+	}
+	return result
+}
+
+type normalizer struct {
+	names       map[ssa.Value]string
+	blocks      map[*ssa.BasicBlock]int
+	constValues bool // 是否记录常量的值
+}
+
+func (n *normalizer) writeInstr(b *bytes.Buffer, instr ssa.Instruction) {
+	if _, ok := instr.(*ssa.DebugRef); ok {
+		return
+	}
+	fmt.Fprintf(b, "%T", instr)
+	if v, ok := instr.(ssa.Value); ok {
+		fmt.Fprintf(b, " %s", types.TypeString(v.Type(), nil))
+	}
+	switch i := instr.(type) {
+	case *ssa.BinOp:
+		fmt.Fprintf(b, " %s", i.Op)
+	case *ssa.UnOp:
+		fmt.Fprintf(b, " %s %v", i.Op, i.CommaOk)
+	case *ssa.Field:
+		fmt.Fprintf(b, " %s", fieldName(i.X.Type(), i.Field))
+	case *ssa.FieldAddr:
+		fmt.Fprintf(b, " %s", fieldName(i.X.Type(), i.Field))
+	case *ssa.Extract:
+		fmt.Fprintf(b, " %d", i.Index)
+	case *ssa.Lookup:
+		fmt.Fprintf(b, " %v", i.CommaOk)
+	case *ssa.TypeAssert:
+		fmt.Fprintf(b, " %s %v", types.TypeString(i.AssertedType, nil), i.CommaOk)
+	case *ssa.Alloc:
+		fmt.Fprintf(b, " %v", i.Heap)
+	case *ssa.Next:
+		fmt.Fprintf(b, " %v", i.IsString)
+	case *ssa.Select:
+		fmt.Fprintf(b, " %v", i.Blocking)
+		for _, state := range i.States {
+			fmt.Fprintf(b, " %v", state.Dir)
+		}
+	case ssa.CallInstruction:
+		if common := i.Common(); common.IsInvoke() {
+			fmt.Fprintf(b, " invoke %s", common.Method.FullName())
+		}
+	}
+	for _, op := range instr.Operands(nil) {
+		b.WriteString(" ")
+		if *op == nil {
+			b.WriteString("_")
+		} else {
+			b.WriteString(n.value(*op))
+		}
+	}
+	b.WriteString("\n")
+}
+
+// fieldName 返回结构体或结构体指针类型 t 的第 index 个字段的名称，字段按名称而不是下标记录，调整字段顺序不改变指纹
+func fieldName(t types.Type, index int) string {
+	if ptr, ok := t.Underlying().(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	if s, ok := t.Underlying().(*types.Struct); ok && index < s.NumFields() {
+		return s.Field(index).Name()
+	}
+	return fmt.Sprintf("#%d", index)
+}
+
+func (n *normalizer) value(v ssa.Value) string {
+	if name, ok := n.names[v]; ok {
+		return name
+	}
+	switch v := v.(type) {
+	case *ssa.Const:
+		if !n.constValues {
+			return "const " + types.TypeString(v.Type(), nil)
+		}
+		return "const " + v.String()
+	case *ssa.Function:
+		if v.Parent() != nil {
+			// 闭包以其规范化指纹表示，与其在源代码中的顺序无关
+			hash := normalizedHash(v, n.constValues)
+			return "closure " + hex.EncodeToString(hash[:])
+		}
+		return "func " + v.String()
+	case *ssa.Global:
+		return "global " + v.String()
+	case *ssa.Builtin:
+		return "builtin " + v.Name()
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// sourceReader 读取函数的源代码文本，源文件只读取、解析一次
+type sourceReader struct {
+	files map[string][]byte
+	fset  *token.FileSet
+	asts  map[string]*ast.File
+}
+
+func newSourceReader() *sourceReader {
+	return &sourceReader{files: make(map[string][]byte), fset: token.NewFileSet(), asts: make(map[string]*ast.File)}
+}
+
+// file 返回源文件的内容，读取不到时返回 nil
+func (r *sourceReader) file(filename string) []byte {
+	content, ok := r.files[filename]
+	if !ok {
+		content, _ = ioutil.ReadFile(filename)
+		r.files[filename] = content
+	}
+	return content
+}
+
+// parseFile 返回源文件的语法树，位置属于 r.fset，解析失败时返回 nil
+func (r *sourceReader) parseFile(filename string) *ast.File {
+	file, ok := r.asts[filename]
+	if !ok {
+		if content := r.file(filename); content != nil {
+			file, _ = parser.ParseFile(r.fset, filename, content, 0)
+		}
+		r.asts[filename] = file
+	}
+	return file
+}
+
+// funcSource 返回函数声明或函数字面量的源代码，合成的函数（如包装函数）或读取不到源文件时返回 false
+func (r *sourceReader) funcSource(f *ssa.Function) ([]byte, bool) {
+	start, end, ok := r.funcExtent(f)
+	if !ok {
+		return nil, false
+	}
+	return r.files[start.Filename][start.Offset:end.Offset], true
+}
+
+// funcExtent 返回函数声明或函数字面量在源文件中的起止位置
+func (r *sourceReader) funcExtent(f *ssa.Function) (token.Position, token.Position, bool) {
+	syntax := f.Syntax()
+	if syntax == nil || f.Synthetic != "" || f.Prog == nil {
+		return token.Position{}, token.Position{}, false
+	}
+	start := f.Prog.Fset.Position(syntax.Pos())
+	end := f.Prog.Fset.Position(syntax.End())
+	content := r.file(start.Filename)
+	if start.Filename != end.Filename || end.Offset > len(content) || start.Offset >= end.Offset {
+		return token.Position{}, token.Position{}, false
+	}
+	return start, end, true
+}
+
+// funcSourceInfo 返回函数的源代码与所在的行，root 为快照的根目录
+func (r *sourceReader) funcSourceInfo(f *ssa.Function, root string) sourceInfo {
+	start, end, ok := r.funcExtent(f)
+	if !ok {
+		return sourceInfo{}
+	}
+	return sourceInfo{
+		File:      relPath(start.Filename, root),
+		StartLine: start.Line,
+		EndLine:   end.Line,
+		Text:      string(r.files[start.Filename][start.Offset:end.Offset]),
+	}
+}
+
+// newASTHasher 返回按函数语法树计算指纹的 funcHasher，只记录节点的种类、标识符、字面量与运算符，与格式和注释无关。
+// 构建 SSA 后不再保留语法树，因此重新解析函数的源代码；没有源代码的函数使用 SSA 指纹
+func newASTHasher() funcHasher {
+	r := newSourceReader()
+	return func(f *ssa.Function) [32]byte {
+		src, ok := r.funcSource(f)
+		if !ok {
+			return getFuncHash(f)
+		}
+		syntax, err := parseFunc(src)
+		if err != nil {
+			return getFuncHash(f)
+		}
+		return astHash(syntax)
+	}
+}
+
+// parseFunc 解析函数声明或函数字面量
+func parseFunc(src []byte) (ast.Node, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), "", "package p;"+string(src), 0)
+	if err == nil && len(file.Decls) == 1 {
+		return file.Decls[0], nil
+	}
+	return parser.ParseExpr(string(src))
+}
+
+func astHash(syntax ast.Node) [32]byte {
+	var b bytes.Buffer
+	ast.Inspect(syntax, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case nil:
+			b.WriteString(")")
+			return false
+		case *ast.CommentGroup, *ast.Comment:
+			return false
+		case *ast.Ident:
+			fmt.Fprintf(&b, "(ident %s", node.Name)
+		case *ast.BasicLit:
+			fmt.Fprintf(&b, "(lit %s %s", node.Kind, node.Value)
+		case *ast.BinaryExpr:
+			fmt.Fprintf(&b, "(binary %s", node.Op)
+		case *ast.UnaryExpr:
+			fmt.Fprintf(&b, "(unary %s", node.Op)
+		case *ast.AssignStmt:
+			fmt.Fprintf(&b, "(assign %s", node.Tok)
+		case *ast.IncDecStmt:
+			fmt.Fprintf(&b, "(incdec %s", node.Tok)
+		case *ast.BranchStmt:
+			fmt.Fprintf(&b, "(branch %s", node.Tok)
+		case *ast.RangeStmt:
+			fmt.Fprintf(&b, "(range %s", node.Tok)
+		case *ast.GenDecl:
+			fmt.Fprintf(&b, "(decl %s", node.Tok)
+		case *ast.ChanType:
+			fmt.Fprintf(&b, "(chan %d", node.Dir)
+		default:
+			fmt.Fprintf(&b, "(%T", node)
+		}
+		return true
+	})
+	return sha256.Sum256(b.Bytes())
+}
+
+// newSourceHasher 返回按函数源代码文本计算指纹的 funcHasher，没有源代码的函数使用 SSA 指纹
+func newSourceHasher() funcHasher {
+	r := newSourceReader()
+	return func(f *ssa.Function) [32]byte {
+		src, ok := r.funcSource(f)
+		if !ok {
+			return getFuncHash(f)
+		}
+		return sha256.Sum256(src)
+	}
+}
@@ -0,0 +1,243 @@
+package analyze
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"golang.org/x/tools/go/callgraph/cha"
+	"golang.org/x/tools/go/callgraph/rta"
+	"golang.org/x/tools/go/callgraph/static"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+	"github.com/bytecamp2021-calldiff/calldiff/view"
+)
+
+const hashBase = `package main
+
+import "fmt"
+
+func sum(values []int) int {
+	total := 0
+	for _, v := range values {
+		if v > 0 {
+			total += v
+		}
+	}
+	return total
+}
+
+func counter() func() int {
+	n := 0
+	return func() int {
+		n++
+		return n
+	}
+}
+
+func main() {
+	next := counter()
+	fmt.Println(sum([]int{1, 2, 3}), next())
+}
+`
+
+// hashRenamed 只重命名了局部变量与参数
+const hashRenamed = `package main
+
+import "fmt"
+
+func sum(xs []int) int {
+	acc := 0
+	for _, x := range xs {
+		if x > 0 {
+			acc += x
+		}
+	}
+	return acc
+}
+
+func counter() func() int {
+	count := 0
+	return func() int {
+		count++
+		return count
+	}
+}
+
+func main() {
+	f := counter()
+	fmt.Println(sum([]int{1, 2, 3}), f())
+}
+`
+
+// hashCommented 只修改了注释与格式
+const hashCommented = `package main
+
+import "fmt"
+
+// sum 返回所有正数之和
+func sum(values []int) int {
+	total := 0 // 累加结果
+	for _, v := range values {
+		// 跳过非正数
+		if v > 0 { total += v }
+	}
+	return total
+}
+
+func counter() func() int {
+	n := 0
+	return func() int { n++; return n }
+}
+
+func main() {
+	next := counter()
+	fmt.Println(sum([]int{1, 2, 3}),
+		next())
+}
+`
+
+// hashModified 修改了 sum 的逻辑
+const hashModified = `package main
+
+import "fmt"
+
+func sum(values []int) int {
+	total := 0
+	for _, v := range values {
+		if v >= 0 {
+			total += v
+		}
+	}
+	return total
+}
+
+func counter() func() int {
+	n := 0
+	return func() int {
+		n++
+		return n
+	}
+}
+
+func main() {
+	next := counter()
+	fmt.Println(sum([]int{1, 2, 3}), next())
+}
+`
+
+// buildHashGraph 将 src 写入临时目录后构建调用图，source 指纹需要读取源文件
+func buildHashGraph(t *testing.T, src string, hashMode string) *Graph {
+	return buildTestGraph(t, src, &common.DiffOptions{HashMode: hashMode})
+}
+
+// buildTestGraph 与 buildHashGraph 相同，o.Algo 为 cha、rta 时使用对应的算法构建调用图，否则只包含静态调用
+func buildTestGraph(t *testing.T, src string, o *common.DiffOptions) *Graph {
+	dir, err := ioutil.TempDir("", "calldiff-hash-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "main.go")
+	if err := ioutil.WriteFile(filename, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, info, err := ssautil.BuildPackage(&types.Config{Importer: importer.Default()}, fset,
+		types.NewPackage("example.com/fixture", "main"), []*ast.File{f}, ssa.SanityCheckFunctions|ssa.InstantiateGenerics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 与 graph 中的 static 算法一致，调用图包含所有函数
+	cg := static.CallGraph(pkg.Prog)
+	for f := range ssautil.AllFunctions(pkg.Prog) {
+		cg.CreateNode(f)
+	}
+	switch o.Algo {
+	case "cha":
+		cg = cha.CallGraph(pkg.Prog)
+	case "rta":
+		cg = rta.Analyze([]*ssa.Function{pkg.Func("init"), pkg.Func("main")}, true).CallGraph
+		cg.DeleteSyntheticNodes()
+	}
+	consts := make(ConstUses)
+	consts.Add(info)
+	g, err := NewGraph(cg, dir, "example.com/fixture", o, consts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// diffNodes 比较 oldSrc 与 newSrc 构建的调用图，返回差异图与其中模块内的节点，以函数名为键
+func diffNodes(t *testing.T, oldSrc string, newSrc string, o *common.DiffOptions) (*view.DiffGraph, map[string]*view.DiffNode) {
+	diffGraph, err := GetDiff(buildTestGraph(t, oldSrc, o), buildTestGraph(t, newSrc, o))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := make(map[string]*view.DiffNode)
+	for _, node := range diffGraph.Nodes {
+		if node.GetPath() == "example.com/fixture" {
+			nodes[node.GetFuncName()] = node
+		}
+	}
+	return diffGraph, nodes
+}
+
+// changedFuncs 返回 src 相对于 hashBase 代码改变的函数
+func changedFuncs(t *testing.T, src string, hashMode string) []string {
+	_, nodes := diffNodes(t, hashBase, src, &common.DiffOptions{HashMode: hashMode})
+	var result []string
+	for name, node := range nodes {
+		if node.Difference == view.CHANGED {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func TestHashModes(t *testing.T) {
+	cases := []struct {
+		name    string
+		src     string
+		mode    string
+		changed []string
+	}{
+		{"rename", hashRenamed, HashSSA, []string{"counter", "sum"}},
+		{"rename", hashRenamed, HashNormalized, nil},
+		{"comment", hashCommented, HashNormalized, nil},
+		{"modify", hashModified, HashNormalized, []string{"sum"}},
+		{"rename", hashRenamed, HashAST, []string{"counter", "main", "sum"}},
+		{"comment", hashCommented, HashAST, nil},
+		{"modify", hashModified, HashAST, []string{"sum"}},
+		{"comment", hashCommented, HashSource, []string{"counter", "main", "sum"}},
+		{"modify", hashModified, HashSource, []string{"sum"}},
+	}
+	for _, c := range cases {
+		changed := changedFuncs(t, c.src, c.mode)
+		sort.Strings(changed)
+		if !reflect.DeepEqual(changed, c.changed) {
+			t.Errorf("%s commit with %s hash: changed functions %v, want %v", c.name, c.mode, changed, c.changed)
+		}
+	}
+}
+
+func TestHashModeUnsupported(t *testing.T) {
+	if _, err := newFuncHasher("md5"); err == nil {
+		t.Error("unsupported hash mode should fail")
+	}
+}
@@ -0,0 +1,240 @@
+package analyze
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/bytecamp2021-calldiff/calldiff/view"
+)
+
+// moveThreshold 删去与新增的函数的相似度不低于该值时视为同一个函数被移动或重命名
+const moveThreshold = 0.6
+
+// 相似度中各部分的权重
+const (
+	bodyWeight      = 0.5  // 函数体：指纹一致为 1，否则为源代码的行相似度
+	signatureWeight = 0.2  // 参数与返回值类型
+	calleeWeight    = 0.15 // 调用的函数
+	callerWeight    = 0.15 // 调用者
+	closureWeight   = 0.4  // 同一个外层函数中的闭包，源代码的词法相似度不低于 closureFloor 时才计入
+)
+
+// closureFloor 同一个外层函数中的两个闭包的源代码按词法单元计算的相似度不低于该值时，才视为可能是同一个闭包
+const closureFloor = 0.5
+
+type moveCandidate struct {
+	oldName    string
+	newName    string
+	confidence float64
+}
+
+// matchMoved 将删去与新增的函数按指纹与调用结构的相似度一一配对，配对的函数标记为 MOVED（所在包改变）或 RENAMED，
+// 同一个外层函数中的闭包标记为 CHANGED 或 UNCHANGED，删去的函数从 diffGraph 中移除。返回新函数名到旧函数名的映射
+func matchMoved(oldGraph *Graph, newGraph *Graph, diffGraph *view.DiffGraph) map[string]string {
+	var removed, inserted []string
+	for key, value := range diffGraph.Nodes {
+		if value.Kind != "" {
+			continue // 全局变量、常量与类型不参与配对
+		}
+		switch value.Difference {
+		case view.REMOVED:
+			removed = append(removed, key)
+		case view.INSERTED:
+			inserted = append(inserted, key)
+		}
+	}
+	var candidates []moveCandidate
+	buckets := newMoveBuckets(newGraph, inserted)
+	for _, oldName := range removed {
+		for _, newName := range buckets.lookup(oldGraph.nodes[oldName]) {
+			confidence := similarity(oldGraph.nodes[oldName], newGraph.nodes[newName])
+			if confidence >= moveThreshold {
+				candidates = append(candidates, moveCandidate{oldName, newName, confidence})
+			}
+		}
+	}
+	// 相似度高的优先配对，相同时按名称排序保证结果稳定
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.confidence != b.confidence {
+			return a.confidence > b.confidence
+		}
+		if a.oldName != b.oldName {
+			return a.oldName < b.oldName
+		}
+		return a.newName < b.newName
+	})
+
+	moved := make(map[string]string)
+	matched := make(map[string]bool)
+	for _, c := range candidates {
+		if matched[c.oldName] || matched[c.newName] {
+			continue
+		}
+		matched[c.oldName], matched[c.newName] = true, true
+		moved[c.newName] = c.oldName
+
+		node := diffGraph.Nodes[c.newName]
+		node.MovedFrom = c.oldName
+		node.Confidence = c.confidence
+		n1, n2 := oldGraph.nodes[c.oldName], newGraph.nodes[c.newName]
+		bodyEqual := isBodyEqual(n1, n2)
+		switch {
+		case closureParent(c.oldName) != "" && closureParent(c.oldName) == closureParent(c.newName):
+			// 闭包以指纹命名，同一个外层函数中的闭包改名说明其代码改变
+			node.Difference = view.UNCHANGED
+			if !bodyEqual {
+				node.Difference = view.CHANGED
+			}
+		case diffGraph.Nodes[c.oldName].GetPath() == node.GetPath():
+			node.Difference = view.RENAMED
+		default:
+			node.Difference = view.MOVED
+		}
+		if !bodyEqual {
+			node.Reason = view.BodyChanged
+			node.Changes = classifyChanges(n1, n2)
+			node.Source = sourceDiff(n1, n2)
+		}
+		delete(diffGraph.Nodes, c.oldName)
+	}
+	return moved
+}
+
+// moveBuckets 按签名、调用与被调用的函数以及闭包的外层函数为新增的函数建立索引。
+// 签名不同、没有共同的调用者与被调用者且不是同一个外层函数中的闭包时，相似度至多为 bodyWeight，低于 moveThreshold，
+// 因此只需对至少有一项相同的函数计算开销较大的源代码相似度
+type moveBuckets map[string][]string
+
+func newMoveBuckets(g *Graph, names []string) moveBuckets {
+	buckets := make(moveBuckets)
+	for _, name := range names {
+		for _, key := range bucketKeys(g.nodes[name]) {
+			buckets[key] = append(buckets[key], name)
+		}
+	}
+	return buckets
+}
+
+// lookup 返回与 n 至少在一个索引中相同的新增函数，按名称排列
+func (b moveBuckets) lookup(n *Node) []string {
+	set := make(map[string]bool)
+	for _, key := range bucketKeys(n) {
+		for _, name := range b[key] {
+			set[name] = true
+		}
+	}
+	return sortedKeys(set)
+}
+
+func bucketKeys(n *Node) []string {
+	keys := []string{fmt.Sprintf("signature %q %q %v", n.facts.Params, n.facts.Results, n.facts.Variadic)}
+	for name := range n.callEdge {
+		keys = append(keys, "callee "+name)
+	}
+	for name := range n.callByEdge {
+		keys = append(keys, "caller "+name)
+	}
+	if parent := closureParent(n.name); parent != "" {
+		keys = append(keys, "closure "+parent)
+	}
+	return keys
+}
+
+// closureParent 返回闭包的外层函数的名称，不是闭包时返回空字符串
+func closureParent(name string) string {
+	splits := strings.Split(name, "#")
+	if len(splits) < 4 {
+		return ""
+	}
+	i := strings.LastIndex(splits[2], "$")
+	if i < 0 {
+		return ""
+	}
+	splits[2] = splits[2][:i]
+	return strings.Join(splits, "#")
+}
+
+// similarity 计算旧版本中的函数 n1 与新版本中的函数 n2 的相似度，取值范围为 [0, 1]
+func similarity(n1 *Node, n2 *Node) float64 {
+	body := 1.0
+	if !isBodyEqual(n1, n2) {
+		body = textSimilarity(n1.source.Text, n2.source.Text)
+	}
+	signature := 0.0
+	if equalStrings(n1.facts.Params, n2.facts.Params) && equalStrings(n1.facts.Results, n2.facts.Results) &&
+		n1.facts.Variadic == n2.facts.Variadic {
+		signature = 1
+	}
+	score := bodyWeight*body + signatureWeight*signature +
+		calleeWeight*jaccard(n1.callEdge, n2.callEdge) + callerWeight*jaccard(n1.callByEdge, n2.callByEdge)
+	// 闭包往往只有一行，行相似度为 0，按词法单元比较整个闭包的源代码，避免只因签名相同就将无关的闭包配对
+	if parent := closureParent(n1.name); parent != "" && parent == closureParent(n2.name) &&
+		(body == 1 || tokenSimilarity(n1.source.Text, n2.source.Text) >= closureFloor) {
+		score = math.Min(score+closureWeight, 1)
+	}
+	return score
+}
+
+// isBodyEqual 判断移动或重命名的函数的函数体是否一致。
+// SSA 等指纹中包含函数名，因此在指纹不一致时比较除去函数声明所在行的源代码，只有一行的函数无法排除函数声明，视为不一致
+func isBodyEqual(n1 *Node, n2 *Node) bool {
+	if isEqual(n1, n2) {
+		return true
+	}
+	linesA, linesB := splitLines(n1.source.Text), splitLines(n2.source.Text)
+	if len(linesA) < 2 || len(linesA) != len(linesB) || !equalStrings(linesA[1:], linesB[1:]) {
+		return false
+	}
+	return equalStrings(n1.facts.Params, n2.facts.Params) && equalStrings(n1.facts.Results, n2.facts.Results)
+}
+
+// textSimilarity 两段源代码的行相似度，即 2*公共行数/总行数，忽略第一行的函数声明
+func textSimilarity(a string, b string) float64 {
+	linesA, linesB := splitLines(a), splitLines(b)
+	if len(linesA) <= 1 || len(linesB) <= 1 {
+		return 0
+	}
+	linesA, linesB = linesA[1:], linesB[1:]
+	common := 0
+	for _, op := range diffLines(linesA, linesB) {
+		if op.kind == ' ' {
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(linesA)+len(linesB))
+}
+
+// tokenSimilarity 两段源代码按空白分隔的词法单元计算的相似度，即 2*公共单元数/总单元数
+func tokenSimilarity(a string, b string) float64 {
+	tokensA, tokensB := strings.Fields(a), strings.Fields(b)
+	if len(tokensA)+len(tokensB) == 0 {
+		return 0
+	}
+	common := 0
+	for _, op := range diffLines(tokensA, tokensB) {
+		if op.kind == ' ' {
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(tokensA)+len(tokensB))
+}
+
+// jaccard 两个函数集合按名称计算的 Jaccard 相似度，两者都为空时为 0
+func jaccard(a map[string]*Node, b map[string]*Node) float64 {
+	union := len(a)
+	common := 0
+	for name := range b {
+		if _, ok := a[name]; ok {
+			common++
+		} else {
+			union++
+		}
+	}
+	if union == 0 {
+		return 0
+	}
+	return float64(common) / float64(union)
+}
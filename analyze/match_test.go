@@ -0,0 +1,118 @@
+package analyze
+
+import (
+	"testing"
+
+	"github.com/bytecamp2021-calldiff/calldiff/view"
+)
+
+const renameOld = `package main
+
+func helper(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n * n
+}
+
+func main() {
+	println(helper(3))
+}
+`
+
+const renameNew = `package main
+
+func square(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n * n
+}
+
+func main() {
+	println(square(3))
+}
+`
+
+func TestMatchRenamed(t *testing.T) {
+	diffGraph, err := GetDiff(buildHashGraph(t, renameOld, HashSSA), buildHashGraph(t, renameNew, HashSSA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const oldName, newName = "example.com/fixture#main#helper#", "example.com/fixture#main#square#"
+	if _, ok := diffGraph.Nodes[oldName]; ok {
+		t.Error("renamed function should not be reported as removed")
+	}
+	node := diffGraph.Nodes[newName]
+	if node.Difference != view.RENAMED || node.MovedFrom != oldName || node.Confidence < moveThreshold {
+		t.Fatalf("square: difference %d, from %q, confidence %.2f", node.Difference, node.MovedFrom, node.Confidence)
+	}
+	main := diffGraph.Nodes["example.com/fixture#main#main#"]
+	if len(main.CallEdge) != 1 || main.CallEdge[newName].Difference != view.UNCHANGED {
+		for name, edge := range main.CallEdge {
+			t.Errorf("main -> %s: %d", name, edge.Difference)
+		}
+	}
+}
+
+func TestMatchMoved(t *testing.T) {
+	const (
+		main     = "example.com/m#main#main#"
+		oldParse = "example.com/m/a#a#Parse#"
+		newParse = "example.com/m/b#b#Parse#"
+		lex      = "example.com/m/lex#lex#Next#"
+		other    = "example.com/m/a#a#Format#"
+	)
+	oldGraph := makeCallGraph([]string{main, oldParse, lex, other},
+		[][]string{{main, oldParse}, {oldParse, lex}, {main, other}})
+	newGraph := makeCallGraph([]string{main, newParse, lex, "example.com/m/b#b#Print#"},
+		[][]string{{main, newParse}, {newParse, lex}, {main, "example.com/m/b#b#Print#"}})
+	// Format 与 Print 的函数体不同，不应配对
+	newGraph.nodes["example.com/m/b#b#Print#"].hashNum[0] = 1
+	oldGraph.nodes[other].facts.Params = []string{"int"}
+
+	diffGraph, err := GetDiff(oldGraph, newGraph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := diffGraph.Nodes[newParse]
+	if node.Difference != view.MOVED || node.MovedFrom != oldParse {
+		t.Fatalf("Parse: difference %d, from %q", node.Difference, node.MovedFrom)
+	}
+	if node.Confidence != 1 {
+		t.Errorf("Parse: confidence %.2f, want 1", node.Confidence)
+	}
+	if edge := node.CallEdge[lex]; edge == nil || edge.Difference != view.UNCHANGED {
+		t.Error("edge Parse -> Next should be kept as unchanged")
+	}
+	if diffGraph.Nodes[other].Difference != view.REMOVED {
+		t.Error("Format should be reported as removed")
+	}
+	if edge := diffGraph.Nodes[main].CallEdge[newParse]; edge == nil || edge.Difference != view.UNCHANGED {
+		t.Error("edge main -> Parse should be kept as unchanged")
+	}
+	if _, ok := diffGraph.Nodes[main].CallEdge[oldParse]; ok {
+		t.Error("main should not show a deleted call to the moved function")
+	}
+}
+
+func TestMoveBuckets(t *testing.T) {
+	const (
+		main   = "example.com/m#main#main#"
+		parse  = "example.com/m/b#b#Parse#"
+		format = "example.com/m/b#b#Format#"
+		old    = "example.com/m/a#a#Parse#"
+	)
+	newGraph := makeCallGraph([]string{main, parse, format}, [][]string{{main, parse}})
+	newGraph.nodes[format].facts.Params = []string{"int"}
+	oldGraph := makeCallGraph([]string{main, old}, [][]string{{main, old}})
+	oldGraph.nodes[old].facts.Params = []string{"string"}
+	// Parse 与原来的函数有共同的调用者；Format 的签名不同且没有共同的调用者与被调用者，相似度不可能达到 moveThreshold
+	got := newMoveBuckets(newGraph, []string{parse, format}).lookup(oldGraph.nodes[old])
+	if !equalStrings(got, []string{parse}) {
+		t.Errorf("candidates %v, want [%s]", got, parse)
+	}
+	if s := similarity(oldGraph.nodes[old], newGraph.nodes[format]); s >= moveThreshold {
+		t.Errorf("similarity to Format %.2f should be below the threshold", s)
+	}
+}
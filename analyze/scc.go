@@ -8,8 +8,9 @@ type Component struct {
 	callEdge   map[int]*Component //所有调用边
 
 	//以下用以过程中计算
-	callNum   int  //调用的函数数
-	isChanged bool //调用的函数中有无发生改变的
+	callNum         int  //调用的函数数
+	isChanged       bool //调用的函数中有无发生改变的
+	isStructChanged bool //调用的函数中有无新增或删去调用的
 }
 
 // ComponentGraph 强连通图
@@ -50,6 +51,9 @@ func (cg *ComponentGraph) traverse() {
 			if com.isChanged {
 				value.isChanged = true
 			}
+			if com.isStructChanged {
+				value.isStructChanged = true
+			}
 			value.callNum--
 			if value.callNum == 0 {
 				queue = append(queue, key)
@@ -64,11 +68,15 @@ func makeSccGraph(g *Graph) *ComponentGraph {
 	for _, com := range cg.nodes {
 		com.callNum = 0
 		com.isChanged = false
+		com.isStructChanged = false
 		for _, mem := range com.member {
 			//标志是否发生改变
 			if mem.isChanged {
 				com.isChanged = true
 			}
+			if mem.isStructChanged {
+				com.isStructChanged = true
+			}
 			for callName := range mem.callEdge {
 				if cg.belongs[callName].id == com.id {
 					continue
@@ -0,0 +1,136 @@
+package analyze
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bytecamp2021-calldiff/calldiff/view"
+)
+
+// diffContext unified diff 中每处改动前后保留的行数
+const diffContext = 3
+
+// sourceDiff 返回函数在两个版本中的位置与源代码的 unified diff，两个版本中都没有源代码时返回 nil
+func sourceDiff(n1 *Node, n2 *Node) *view.SourceDiff {
+	s1, s2 := n1.source, n2.source
+	if s1.Text == "" && s2.Text == "" {
+		return nil
+	}
+	return &view.SourceDiff{
+		OldFile:      s1.File,
+		OldStartLine: s1.StartLine,
+		OldEndLine:   s1.EndLine,
+		NewFile:      s2.File,
+		NewStartLine: s2.StartLine,
+		NewEndLine:   s2.EndLine,
+		Diff:         unifiedDiff("a/"+s1.File, "b/"+s2.File, s1.StartLine, s2.StartLine, splitLines(s1.Text), splitLines(s2.Text)),
+	}
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// diffOp 编辑脚本中的一行，kind 为 ' '、'-' 或 '+'，oldIndex、newIndex 为该行之前两侧已经消耗的行数
+type diffOp struct {
+	kind     byte
+	text     string
+	oldIndex int
+	newIndex int
+}
+
+// diffLines 按最长公共子序列求出由 a 变为 b 的编辑脚本
+func diffLines(a []string, b []string) []diffOp {
+	n, m := len(a), len(b)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var ops []diffOp
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i], i, j})
+			i++
+			j++
+		case j == m || (i < n && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i], i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j], i, j})
+			j++
+		}
+	}
+	return ops
+}
+
+// unifiedDiff 生成 a 与 b 之间的 unified diff，oldStart、newStart 为 a、b 第一行在源文件中的行号，没有差异时返回空字符串
+func unifiedDiff(oldName string, newName string, oldStart int, newStart int, a []string, b []string) string {
+	ops := diffLines(a, b)
+	var sb strings.Builder
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// 相距不超过 2*diffContext 行的改动合并到同一个 hunk 中
+		start, end := i-diffContext, i
+		if start < 0 {
+			start = 0
+		}
+		for j := i + 1; j < len(ops) && j-end <= 2*diffContext; j++ {
+			if ops[j].kind != ' ' {
+				end = j
+			}
+		}
+		stop := end + diffContext + 1
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		oldCount, newCount := 0, 0
+		for _, op := range ops[start:stop] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n",
+			hunkRange(oldStart+ops[start].oldIndex, oldCount), hunkRange(newStart+ops[start].newIndex, newCount))
+		for _, op := range ops[start:stop] {
+			fmt.Fprintf(&sb, "%c%s\n", op.kind, op.text)
+		}
+		i = stop
+	}
+	return sb.String()
+}
+
+// hunkRange 与 diff -u 一致，范围为空时起始行为其前一行
+func hunkRange(line int, count int) string {
+	if count == 0 {
+		line--
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
@@ -0,0 +1,53 @@
+package analyze
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := strings.Split("func f() {\n\ta := 1\n\tb := 2\n\tc := 3\n\td := 4\n\te := 5\n\tf := 6\n\tg := 7\n\th := 8\n\ti := 9\n\treturn\n}", "\n")
+	b := strings.Split("func f() {\n\ta := 1\n\tb := 20\n\tc := 3\n\td := 4\n\te := 5\n\tf := 6\n\tg := 7\n\th := 8\n\ti := 9\n\tlog()\n\treturn\n}", "\n")
+	want := `--- a/f.go
++++ b/f.go
+@@ -11,6 +21,6 @@
+ func f() {
+ 	a := 1
+-	b := 2
++	b := 20
+ 	c := 3
+ 	d := 4
+ 	e := 5
+@@ -18,5 +28,6 @@
+ 	g := 7
+ 	h := 8
+ 	i := 9
++	log()
+ 	return
+ }
+`
+	if got := unifiedDiff("a/f.go", "b/f.go", 11, 21, a, b); got != want {
+		t.Errorf("unifiedDiff =\n%s\nwant\n%s", got, want)
+	}
+	if got := unifiedDiff("a/f.go", "b/f.go", 1, 1, a, a); got != "" {
+		t.Errorf("unifiedDiff of identical text = %q", got)
+	}
+}
+
+func TestSourceDiff(t *testing.T) {
+	diffGraph, err := GetDiff(buildHashGraph(t, changesOld, HashSSA), buildHashGraph(t, changesNew, HashSSA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := diffGraph.Nodes["example.com/fixture#main#scale#"].Source
+	if source == nil {
+		t.Fatal("changed function should have source diff")
+	}
+	if source.NewFile != "main.go" || source.OldStartLine != 23 || source.OldEndLine != 25 ||
+		source.NewStartLine != 31 || source.NewEndLine != 33 {
+		t.Errorf("source location = %+v", source)
+	}
+	if !strings.Contains(source.Diff, "-\treturn n * 2\n+\treturn n * 3\n") {
+		t.Errorf("source diff =\n%s", source.Diff)
+	}
+}
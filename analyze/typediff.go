@@ -0,0 +1,211 @@
+package analyze
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"go/types"
+	"sort"
+	"strings"
+
+	"github.com/bytecamp2021-calldiff/calldiff/view"
+)
+
+// 类型具体改变的内容
+const (
+	ChangeFieldsAdded    = "fields_added"       // 新增了字段
+	ChangeFieldsRemoved  = "fields_removed"     // 删去了字段
+	ChangeFieldsChanged  = "fields_changed"     // 字段的类型或是否嵌入改变
+	ChangeFieldsOrder    = "fields_reordered"   // 字段不变，顺序改变
+	ChangeTagsChanged    = "tags_changed"       // 字段的标签（如 json 标签）改变
+	ChangeMethodsAdded   = "methods_added"      // 方法集新增了方法
+	ChangeMethodsRemoved = "methods_removed"    // 方法集删去了方法
+	ChangeMethodsChanged = "methods_changed"    // 方法的签名改变
+	ChangeUnderlying     = "underlying_changed" // 非结构体、接口的类型的底层类型改变
+)
+
+// typeFacts 命名类型的结构化信息，用于比较类型在两个版本中的差异
+type typeFacts struct {
+	Kind       string      // struct、interface 或 other
+	Fields     []fieldFact // 结构体的字段
+	Methods    []string    // 方法集，形如 Name func(...)，接口为其全部方法，其他类型为声明的方法
+	Underlying string      // 非结构体、接口的类型的底层类型
+}
+
+type fieldFact struct {
+	Name     string
+	Type     string
+	Tag      string
+	Embedded bool
+}
+
+// getTypeFacts 从 go/types 中收集命名类型的字段与方法集，类型以相对于其所在包的形式表示
+func getTypeFacts(named *types.Named) typeFacts {
+	var facts typeFacts
+	qualifier := types.RelativeTo(named.Obj().Pkg())
+	method := func(f *types.Func) string {
+		return f.Name() + " " + types.TypeString(f.Type(), qualifier)
+	}
+	switch underlying := named.Underlying().(type) {
+	case *types.Struct:
+		facts.Kind = "struct"
+		for i := 0; i < underlying.NumFields(); i++ {
+			field := underlying.Field(i)
+			facts.Fields = append(facts.Fields, fieldFact{
+				Name:     field.Name(),
+				Type:     types.TypeString(field.Type(), qualifier),
+				Tag:      underlying.Tag(i),
+				Embedded: field.Embedded(),
+			})
+		}
+	case *types.Interface:
+		facts.Kind = "interface"
+		for i := 0; i < underlying.NumMethods(); i++ {
+			facts.Methods = append(facts.Methods, method(underlying.Method(i)))
+		}
+	default:
+		facts.Kind = "other"
+		facts.Underlying = types.TypeString(underlying, qualifier)
+	}
+	if facts.Kind != "interface" {
+		for i := 0; i < named.NumMethods(); i++ {
+			facts.Methods = append(facts.Methods, method(named.Method(i)))
+		}
+	}
+	sort.Strings(facts.Methods)
+	return facts
+}
+
+// hash 返回类型的指纹，字段按声明顺序参与计算
+func (t *typeFacts) hash() [32]byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", t.Kind, t.Underlying)
+	for _, field := range t.Fields {
+		fmt.Fprintf(h, "field %s %s %q %t\n", field.Name, field.Type, field.Tag, field.Embedded)
+	}
+	for _, method := range t.Methods {
+		fmt.Fprintf(h, "method %s\n", method)
+	}
+	var result [32]byte
+	copy(result[:], h.Sum(nil))
+	return result
+}
+
+// namedTypes 收集 t 中出现的命名类型，不展开命名类型本身；泛型类型的实例记为其泛型类型
+func namedTypes(t types.Type, refs map[*types.TypeName]bool) {
+	switch t := types.Unalias(t).(type) {
+	case *types.Named:
+		refs[t.Origin().Obj()] = true
+		for i := 0; i < t.TypeArgs().Len(); i++ {
+			namedTypes(t.TypeArgs().At(i), refs)
+		}
+	case *types.Pointer:
+		namedTypes(t.Elem(), refs)
+	case *types.Slice:
+		namedTypes(t.Elem(), refs)
+	case *types.Array:
+		namedTypes(t.Elem(), refs)
+	case *types.Chan:
+		namedTypes(t.Elem(), refs)
+	case *types.Map:
+		namedTypes(t.Key(), refs)
+		namedTypes(t.Elem(), refs)
+	case *types.Signature:
+		namedTypes(t.Params(), refs)
+		namedTypes(t.Results(), refs)
+	case *types.Tuple:
+		for i := 0; i < t.Len(); i++ {
+			namedTypes(t.At(i).Type(), refs)
+		}
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			namedTypes(t.Field(i).Type(), refs)
+		}
+	case *types.Interface:
+		for i := 0; i < t.NumExplicitMethods(); i++ {
+			namedTypes(t.ExplicitMethod(i).Type(), refs)
+		}
+	}
+}
+
+// typeChange 比较类型在两个版本中的结构化信息，返回具体改变的内容与改变的字段、方法
+func typeChange(t1 *typeFacts, t2 *typeFacts) ([]string, *view.TypeChange) {
+	change := &view.TypeChange{Kind: t2.Kind}
+	fields1, fields2 := make(map[string]fieldFact), make(map[string]fieldFact)
+	for _, field := range t1.Fields {
+		fields1[field.Name] = field
+	}
+	for _, field := range t2.Fields {
+		fields2[field.Name] = field
+		old, ok := fields1[field.Name]
+		switch {
+		case !ok:
+			change.FieldsAdded = append(change.FieldsAdded, field.Name)
+		case old.Type != field.Type || old.Embedded != field.Embedded:
+			change.FieldsChanged = append(change.FieldsChanged, field.Name)
+		case old.Tag != field.Tag:
+			change.TagsChanged = append(change.TagsChanged, field.Name)
+		}
+	}
+	for _, field := range t1.Fields {
+		if _, ok := fields2[field.Name]; !ok {
+			change.FieldsRemoved = append(change.FieldsRemoved, field.Name)
+		}
+	}
+
+	methods1, methods2 := methodsByName(t1.Methods), methodsByName(t2.Methods)
+	for name, signature := range methods2 {
+		if old, ok := methods1[name]; !ok {
+			change.MethodsAdded = append(change.MethodsAdded, name)
+		} else if old != signature {
+			change.MethodsChanged = append(change.MethodsChanged, name)
+		}
+	}
+	for name := range methods1 {
+		if _, ok := methods2[name]; !ok {
+			change.MethodsRemoved = append(change.MethodsRemoved, name)
+		}
+	}
+	sort.Strings(change.MethodsAdded)
+	sort.Strings(change.MethodsRemoved)
+	sort.Strings(change.MethodsChanged)
+	change.UnderlyingChanged = t1.Kind != t2.Kind || t1.Underlying != t2.Underlying
+
+	var changes []string
+	add := func(changed bool, c string) {
+		if changed {
+			changes = append(changes, c)
+		}
+	}
+	add(len(change.FieldsAdded) > 0, ChangeFieldsAdded)
+	add(len(change.FieldsRemoved) > 0, ChangeFieldsRemoved)
+	add(len(change.FieldsChanged) > 0, ChangeFieldsChanged)
+	add(len(changes) == 0 && !sameFieldOrder(t1.Fields, t2.Fields), ChangeFieldsOrder)
+	add(len(change.TagsChanged) > 0, ChangeTagsChanged)
+	add(len(change.MethodsAdded) > 0, ChangeMethodsAdded)
+	add(len(change.MethodsRemoved) > 0, ChangeMethodsRemoved)
+	add(len(change.MethodsChanged) > 0, ChangeMethodsChanged)
+	add(change.UnderlyingChanged, ChangeUnderlying)
+	return changes, change
+}
+
+func sameFieldOrder(a []fieldFact, b []fieldFact) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name {
+			return false
+		}
+	}
+	return true
+}
+
+// methodsByName 将形如 Name func(...) 的方法按名称索引到签名
+func methodsByName(methods []string) map[string]string {
+	result := make(map[string]string)
+	for _, method := range methods {
+		name, signature, _ := strings.Cut(method, " ")
+		result[name] = signature
+	}
+	return result
+}
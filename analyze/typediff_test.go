@@ -0,0 +1,138 @@
+package analyze
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+	"github.com/bytecamp2021-calldiff/calldiff/view"
+)
+
+const typesOld = `package main
+
+type Config struct {
+	Name string ` + "`json:\"name\"`" + `
+	Port int
+}
+
+type Outer struct {
+	C Config
+}
+
+type Store interface {
+	Get() string
+}
+
+func UseConfig(c Config) string {
+	return c.Name
+}
+
+func MakeOuter() Outer {
+	return Outer{}
+}
+
+func Open(s Store) string {
+	return s.Get()
+}
+
+func main() {
+	println(UseConfig(Config{}), MakeOuter().C.Port, Open(nil))
+}
+`
+
+// typesNew 改变了 Config 的标签并新增了字段，Store 新增了方法，函数的代码不变
+const typesNew = `package main
+
+type Config struct {
+	Name  string ` + "`json:\"full_name\"`" + `
+	Port  int
+	Debug bool
+}
+
+type Outer struct {
+	C Config
+}
+
+type Store interface {
+	Get() string
+	Put(string)
+}
+
+func UseConfig(c Config) string {
+	return c.Name
+}
+
+func MakeOuter() Outer {
+	return Outer{}
+}
+
+func Open(s Store) string {
+	return s.Get()
+}
+
+func main() {
+	println(UseConfig(Config{}), MakeOuter().C.Port, Open(nil))
+}
+`
+
+func TestTypeChanges(t *testing.T) {
+	for _, mode := range []string{HashSSA, HashAST} {
+		testTypeChanges(t, mode)
+	}
+}
+
+func testTypeChanges(t *testing.T, mode string) {
+	o := &common.DiffOptions{HashMode: mode, PrintPrivate: true, Pkg: "main"}
+	diffGraph, nodes := diffNodes(t, typesOld, typesNew, o)
+	config, store := nodes["Config"], nodes["Store"]
+	if config == nil || store == nil {
+		t.Fatalf("%s hash: missing types in %v", mode, nodes)
+	}
+	if config.Difference != view.CHANGED || config.Reason != view.TypeChanged ||
+		!equalStrings(config.Changes, []string{ChangeFieldsAdded, ChangeTagsChanged}) {
+		t.Errorf("%s hash: Config: difference %d, reason %q, changes %v", mode, config.Difference, config.Reason, config.Changes)
+	}
+	if !equalStrings(config.TypeChange.FieldsAdded, []string{"Debug"}) || !equalStrings(config.TypeChange.TagsChanged, []string{"Name"}) {
+		t.Errorf("%s hash: Config: %+v", mode, config.TypeChange)
+	}
+	if !equalStrings(store.Changes, []string{ChangeMethodsAdded}) || !equalStrings(store.TypeChange.MethodsAdded, []string{"Put"}) {
+		t.Errorf("%s hash: Store: changes %v, %+v", mode, store.Changes, store.TypeChange)
+	}
+	// 用到改变的类型的函数与类型受影响，经 Outer 间接用到 Config 的函数同样受影响
+	for name, distance := range map[string]int{"UseConfig": 1, "Outer": 1, "MakeOuter": 2, "Open": 1} {
+		node := nodes[name]
+		if node.Difference != view.AFFECTED || node.Distance != distance {
+			t.Errorf("%s hash: %s: difference %d, distance %d", mode, name, node.Difference, node.Distance)
+		}
+	}
+
+	output := view.NewOutput(diffGraph, o)
+	differences := make(map[string]string)
+	for _, change := range output.ChangeList.TypeChanges {
+		differences[change.Name] = change.Difference
+		if change.Name == "main.Config" && !equalStrings(change.Users, []string{"main.Outer", "main.UseConfig", "main.main"}) {
+			t.Errorf("%s hash: Config used by %v", mode, change.Users)
+		}
+	}
+	if len(differences) != 3 || differences["main.Config"] != "changed" || differences["main.Outer"] != "affected" {
+		t.Errorf("%s hash: type changes: %v", mode, differences)
+	}
+}
+
+func TestFieldReorder(t *testing.T) {
+	// 字段按名称记录，调整字段顺序时访问字段的函数的代码不变，只受类型改变的影响
+	src := strings.Replace(typesOld, "\tPort int\n", "", 1)
+	src = strings.Replace(src, "type Config struct {\n", "type Config struct {\n\tPort int\n", 1)
+	for _, mode := range []string{HashSSA, HashNormalized} {
+		o := &common.DiffOptions{HashMode: mode, PrintPrivate: true, Pkg: "main"}
+		_, nodes := diffNodes(t, typesOld, src, o)
+		if config := nodes["Config"]; !equalStrings(config.Changes, []string{ChangeFieldsOrder}) {
+			t.Errorf("%s hash: Config: changes %v", mode, config.Changes)
+		}
+		for _, name := range []string{"UseConfig", "main"} {
+			if node := nodes[name]; node.Difference != view.AFFECTED {
+				t.Errorf("%s hash: %s: difference %d, want affected", mode, name, node.Difference)
+			}
+		}
+	}
+}
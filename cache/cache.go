@@ -0,0 +1,121 @@
+// Package cache 将构建好的调用图按提交与构建配置缓存在磁盘上，
+// 命中缓存的一侧无需再加载包与构建 SSA。
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bytecamp2021-calldiff/calldiff/analyze"
+)
+
+// entrySuffix 缓存文件的后缀
+const entrySuffix = ".graph"
+
+// Key 决定调用图内容的全部输入。
+// 除提交、Go 版本、构建标签、算法、函数指纹、闭包是否单独输出与是否加载测试外，根函数的选取（Pkg、Private）也会影响 rta 的结果
+type Key struct {
+	Commit    string
+	GoVersion string
+	Tags      []string
+	Algo      string
+	Hash      string
+	Closures  bool
+	Test      bool
+	Pkg       string
+	Private   bool
+}
+
+// String 返回 key 的摘要，作为缓存文件名
+func (k Key) String() string {
+	s := fmt.Sprintf("commit=%s\ngo=%s\ntags=%s\nalgo=%s\nhash=%s\nclosures=%v\ntest=%v\npkg=%s\nprivate=%v\n",
+		k.Commit, k.GoVersion, strings.Join(k.Tags, ","), k.Algo, k.Hash, k.Closures, k.Test, k.Pkg, k.Private)
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// Cache 位于 Dir 目录下的调用图缓存
+type Cache struct {
+	Dir string
+}
+
+func (c *Cache) path(key Key) string {
+	return filepath.Join(c.Dir, key.String()+entrySuffix)
+}
+
+// Load 读取 key 对应的调用图，未命中时返回 false。
+// 无法解析（例如序列化格式已经升级）的缓存会被删除
+func (c *Cache) Load(key Key) (*analyze.Graph, bool) {
+	path := c.path(key)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	g, err := analyze.DecodeGraph(f)
+	_ = f.Close()
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, false
+	}
+	// 更新修改时间，prune 按最近一次使用的时间清理
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return g, true
+}
+
+// Store 保存 key 对应的调用图，先写入临时文件再重命名，避免并发读到不完整的缓存
+func (c *Cache) Store(key Key, g *analyze.Graph) error {
+	if err := os.MkdirAll(c.Dir, os.ModePerm); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(c.Dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	if err := g.Encode(f); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), c.path(key))
+}
+
+// Prune 删除超过 maxAge 未被使用的缓存，maxAge 为 0 时删除全部缓存，返回删除的个数
+func (c *Cache) Prune(maxAge time.Duration) (int, error) {
+	entries, err := ioutil.ReadDir(c.Dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		isEntry := strings.HasSuffix(entry.Name(), entrySuffix)
+		isTemp := strings.HasPrefix(entry.Name(), "tmp-")
+		if !isEntry && !isTemp {
+			continue
+		}
+		if maxAge > 0 && time.Since(entry.ModTime()) <= maxAge {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.Dir, entry.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"bytes"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/go/callgraph/static"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+
+	"github.com/bytecamp2021-calldiff/calldiff/analyze"
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+)
+
+func buildGraph(t *testing.T) *analyze.Graph {
+	const src = `package main
+
+func helper() int { return 1 }
+
+func main() { println(helper()) }
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "/snapshot/main.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, _, err := ssautil.BuildPackage(&types.Config{Importer: importer.Default()}, fset,
+		types.NewPackage("example.com/fixture", "main"), []*ast.File{f}, ssa.SanityCheckFunctions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := analyze.NewGraph(static.CallGraph(pkg.Prog), "/snapshot", "example.com/fixture", &common.DiffOptions{HashMode: analyze.HashSSA}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func encode(t *testing.T, g *analyze.Graph) []byte {
+	var b bytes.Buffer
+	if err := g.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calldiff-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := &Cache{Dir: filepath.Join(dir, "graphs")}
+	key := Key{Commit: "0123456789abcdef", GoVersion: "go1.17", Algo: "rta", Pkg: "main"}
+
+	if _, ok := c.Load(key); ok {
+		t.Fatal("empty cache should miss")
+	}
+	g := buildGraph(t)
+	if err := c.Store(key, g); err != nil {
+		t.Fatal(err)
+	}
+	cached, ok := c.Load(key)
+	if !ok {
+		t.Fatal("stored graph should hit")
+	}
+	if !bytes.Equal(encode(t, g), encode(t, cached)) {
+		t.Error("cached graph differs from the stored one")
+	}
+	if cached.Module() != "example.com/fixture" {
+		t.Errorf("module = %q", cached.Module())
+	}
+
+	// 构建配置中的任何一项不同都不应命中
+	for _, other := range []Key{
+		{Commit: key.Commit, GoVersion: "go1.18", Algo: key.Algo, Pkg: key.Pkg},
+		{Commit: key.Commit, GoVersion: key.GoVersion, Algo: "cha", Pkg: key.Pkg},
+		{Commit: key.Commit, GoVersion: key.GoVersion, Algo: key.Algo, Pkg: key.Pkg, Test: true},
+		{Commit: key.Commit, GoVersion: key.GoVersion, Algo: key.Algo, Pkg: key.Pkg, Tags: []string{"integration"}},
+	} {
+		if _, ok := c.Load(other); ok {
+			t.Errorf("key %+v should miss", other)
+		}
+	}
+
+	// 无法解析的缓存视为未命中并被删除
+	if err := ioutil.WriteFile(c.path(key), []byte("corrupted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Load(key); ok {
+		t.Error("corrupted entry should miss")
+	}
+	if _, err := os.Stat(c.path(key)); !os.IsNotExist(err) {
+		t.Error("corrupted entry should be removed")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calldiff-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := &Cache{Dir: dir}
+	g := buildGraph(t)
+	oldKey, newKey := Key{Commit: "old"}, Key{Commit: "new"}
+	for _, key := range []Key{oldKey, newKey} {
+		if err := c.Store(key, g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	past := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(c.path(oldKey), past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := c.Prune(24 * time.Hour)
+	if err != nil || removed != 1 {
+		t.Fatalf("Prune(24h) = %d, %v, want 1", removed, err)
+	}
+	if _, ok := c.Load(oldKey); ok {
+		t.Error("stale entry should be pruned")
+	}
+	if _, ok := c.Load(newKey); !ok {
+		t.Error("recent entry should be kept")
+	}
+	removed, err = c.Prune(0)
+	if err != nil || removed != 1 {
+		t.Fatalf("Prune(0) = %d, %v, want 1", removed, err)
+	}
+}
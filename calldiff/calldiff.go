@@ -0,0 +1,202 @@
+// Package calldiff 提供比较两个版本之间函数调用图差异的库接口，
+// 所有结果都在内存中返回或写入调用方提供的 io.Writer，不会退出进程。
+package calldiff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/bytecamp2021-calldiff/calldiff/analyze"
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+	"github.com/bytecamp2021-calldiff/calldiff/graph"
+	"github.com/bytecamp2021-calldiff/calldiff/view"
+)
+
+// Options 比较选项，DiffOptions.Output 仅供命令行使用，库调用时输出由 Writers 决定
+type Options struct {
+	common.DiffOptions
+	Old string // 旧版本的 revision
+	New string // 新版本的 revision，也可以是 graph.Worktree 或 graph.Index
+	// Writers 各输出格式对应的 Writer，支持的格式见 view.OutputTypes，
+	// 为 --range 生成汇总报告时支持 json 和 markdown
+	Writers map[string]io.Writer
+	// Log 接收 clone、缓存命中、--range 进度等提示信息，每条一行，警告以 common.WarningPrefix 开头；
+	// 为 nil 时丢弃
+	Log io.Writer
+}
+
+// Result 比较结果
+type Result struct {
+	Old       string // 实际用作旧版本的 revision（merge-base 模式下为 merge base 的 hash）
+	New       string
+	DiffGraph *view.DiffGraph
+	Report    view.Output
+}
+
+// Run 比较 opts.Old 与 opts.New 两个版本的函数调用图，并将差异写入 opts.Writers
+func Run(ctx context.Context, opts Options) (*Result, error) {
+	if _, err := view.ParsePathRoots(opts.PathRoots); err != nil {
+		return nil, common.WrapError(common.ExitAnalysisError, err)
+	}
+	if err := graph.CheckAlgo(opts.Algo); err != nil {
+		return nil, common.WrapError(common.ExitAnalysisError, err)
+	}
+	if err := view.CheckRenderer(opts.Renderer); err != nil {
+		return nil, common.WrapError(common.ExitOutputError, err)
+	}
+	repo, err := graph.OpenRepository(ctx, opts.URL, opts.Dir, opts.Log)
+	if err != nil {
+		return nil, err
+	}
+	source := common.GraphOptions{Commit: opts.Old}
+	target := common.GraphOptions{Commit: opts.New}
+	if err := graph.ResolveBase(repo, &opts.DiffOptions, &source, &target); err != nil {
+		return nil, err
+	}
+
+	sides := []*side{{name: "old", options: &source}, {name: "new", options: &target}}
+	var wg sync.WaitGroup
+	for _, s := range sides {
+		wg.Add(1)
+		go func(s *side) {
+			defer wg.Done()
+			s.graph, s.err = graph.GetCallGraph(ctx, repo, &opts.DiffOptions, s.options)
+		}(s)
+	}
+	wg.Wait()
+	if err := checkSides(sides...); err != nil {
+		return nil, err
+	}
+
+	diffGraph, err := analyze.GetDiff(sides[0].graph, sides[1].graph)
+	if err != nil {
+		return nil, err
+	}
+	diffGraph.FilterDistance(opts.MaxDistance)
+	if err := diffGraph.FindPaths(&opts.DiffOptions); err != nil {
+		return nil, common.WrapError(common.ExitAnalysisError, err)
+	}
+	result := &Result{
+		Old:       source.Commit,
+		New:       target.Commit,
+		DiffGraph: diffGraph,
+		Report:    view.NewOutput(diffGraph, &opts.DiffOptions),
+	}
+	if err := diffGraph.OutputDiffGraph(&opts.DiffOptions, opts.Writers); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// RunRange 逐个比较 opts.Range 范围内的每个提交与其第一个父提交，
+// 前一个提交的调用图会被复用为后一个提交的旧版本，汇总报告写入 opts.Writers 中的 json 与 markdown
+func RunRange(ctx context.Context, opts Options) (*view.RangeReport, error) {
+	if _, err := view.ParsePathRoots(opts.PathRoots); err != nil {
+		return nil, common.WrapError(common.ExitAnalysisError, err)
+	}
+	if err := graph.CheckAlgo(opts.Algo); err != nil {
+		return nil, common.WrapError(common.ExitAnalysisError, err)
+	}
+	repo, err := graph.OpenRepository(ctx, opts.URL, opts.Dir, opts.Log)
+	if err != nil {
+		return nil, err
+	}
+	commits, err := graph.RangeCommits(repo, &opts.DiffOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &view.RangeReport{Range: opts.Range, Pkg: opts.Pkg, Algo: opts.Algo}
+	var prev *side
+	for _, commit := range commits {
+		if commit.NumParents() == 0 {
+			common.Logf(opts.Log, common.WarningPrefix+"skip root commit %s", commit.Hash)
+			continue
+		}
+		parent := commit.ParentHashes[0].String()
+		subject := strings.SplitN(commit.Message, "\n", 2)[0]
+		common.Logf(opts.Log, "analysing %s %s", commit.Hash, subject)
+
+		// 上一个提交的调用图即为本提交的旧版本，无需重复构建
+		source := prev
+		if source == nil || source.options.Hash != parent {
+			source = &side{name: "old", options: &common.GraphOptions{Commit: parent}}
+			source.graph, source.err = graph.GetCallGraph(ctx, repo, &opts.DiffOptions, source.options)
+			if err := checkSides(source); err != nil {
+				return nil, err
+			}
+		}
+		target := &side{name: "new", options: &common.GraphOptions{Commit: commit.Hash.String()}}
+		target.graph, target.err = graph.GetCallGraph(ctx, repo, &opts.DiffOptions, target.options)
+		if err := checkSides(target); err != nil {
+			return nil, err
+		}
+
+		diffGraph, err := analyze.GetDiff(source.graph, target.graph)
+		if err != nil {
+			return nil, err
+		}
+		diffGraph.FilterDistance(opts.MaxDistance)
+		if err := diffGraph.FindPaths(&opts.DiffOptions); err != nil {
+			return nil, common.WrapError(common.ExitAnalysisError, err)
+		}
+		output := view.NewOutput(diffGraph, &opts.DiffOptions)
+		author := fmt.Sprintf("%s <%s>", commit.Author.Name, commit.Author.Email)
+		report.AddCommit(commit.Hash.String(), parent, author, subject, output)
+		prev = target
+	}
+
+	if err := outputRangeReport(report, opts.Writers); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+func outputRangeReport(report *view.RangeReport, writers map[string]io.Writer) error {
+	for output, w := range writers {
+		var err error
+		switch output {
+		case "json":
+			err = report.WriteJSON(w)
+		case "markdown":
+			err = report.WriteMarkdown(w)
+		default:
+			err = fmt.Errorf("unsupported output type")
+		}
+		if err != nil {
+			return common.WrapError(common.ExitOutputError, fmt.Errorf("%s: %w", output, err))
+		}
+	}
+	return nil
+}
+
+// side 参与比较的一侧
+type side struct {
+	name    string
+	options *common.GraphOptions
+	graph   *analyze.Graph
+	err     error
+}
+
+// checkSides 汇总每一侧构建调用图时的错误，返回的错误带有第一个失败一侧的退出码
+func checkSides(sides ...*side) error {
+	var errs []error
+	code := 0
+	for _, s := range sides {
+		if s.err == nil {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%s side (%s) failed: %w", s.name, s.options.Commit, s.err))
+		if code == 0 {
+			code = common.ExitCode(s.err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &common.ExitError{Code: code, Err: errors.Join(errs...)}
+}
@@ -0,0 +1,175 @@
+package calldiff
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/bytecamp2021-calldiff/calldiff/view"
+)
+
+const fixtureMain = `package main
+
+func sum(a, b int) int { return a + b }
+
+func main() { println(sum(1, 2)) }
+`
+
+const fixtureModified = `package main
+
+func sum(a, b int) int { return b + a + 0*a }
+
+func main() { println(sum(1, 2)) }
+`
+
+const fixtureAdded = `package main
+
+func sum(a, b int) int { return b + a + 0*a }
+
+func twice(a int) int { return sum(a, a) }
+
+func main() { println(sum(1, 2), twice(3)) }
+`
+
+// newFixture 在临时目录中构造一个依次提交 fixtureMain、fixtureModified 与 fixtureAdded 的仓库，返回仓库目录与各提交
+func newFixture(t *testing.T) (string, []plumbing.Hash) {
+	dir, err := ioutil.TempDir("", "calldiff-lib-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	when := time.Unix(1600000000, 0)
+	var commits []plumbing.Hash
+	for i, files := range []map[string]string{
+		{"go.mod": "module example.com/fixture\n\ngo 1.18\n", "main.go": fixtureMain},
+		{"main.go": fixtureModified},
+		{"main.go": fixtureAdded},
+	} {
+		for name, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Add(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sig := &object.Signature{Name: "calldiff", Email: "calldiff@example.com", When: when.Add(time.Duration(i) * time.Minute)}
+		hash, err := w.Commit("commit "+string(rune('a'+i)), &git.CommitOptions{Author: sig})
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits = append(commits, hash)
+	}
+	return dir, commits
+}
+
+func TestRun(t *testing.T) {
+	dir, _ := newFixture(t)
+	buffers := make(map[string]*bytes.Buffer)
+	writers := make(map[string]io.Writer)
+	for _, output := range view.OutputTypes {
+		buffers[output] = &bytes.Buffer{}
+		writers[output] = buffers[output]
+	}
+	var log bytes.Buffer
+	opts := Options{Old: "HEAD~2", New: "HEAD~1", Writers: writers, Log: &log}
+	opts.Dir = dir
+	opts.Pkg = "main"
+	opts.PrintPrivate = true
+	opts.Renderer = view.RendererBuiltin
+
+	result, err := Run(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DiffGraph == nil || len(result.DiffGraph.Nodes) == 0 {
+		t.Fatal("Result.DiffGraph is empty")
+	}
+	if n := result.DiffGraph.Nodes["example.com/fixture#main#sum#"]; n == nil || n.Difference != view.CHANGED {
+		t.Errorf("sum is not CHANGED in Result.DiffGraph: %+v", n)
+	}
+	var modified []string
+	for _, m := range result.Report.ChangeList.Modified {
+		modified = append(modified, m.Name)
+	}
+	if !strings.Contains(strings.Join(modified, ","), "main.sum") {
+		t.Errorf("Result.Report modified = %v, want main.sum in it", modified)
+	}
+	for output, b := range buffers {
+		if b.Len() == 0 {
+			t.Errorf("nothing was written to the %s writer", output)
+		}
+	}
+	if !strings.Contains(buffers["json"].String(), "sum") {
+		t.Errorf("json output does not mention sum:\n%s", buffers["json"].String())
+	}
+}
+
+func TestRunRange(t *testing.T) {
+	dir, commits := newFixture(t)
+	var jsonOut, markdownOut, log bytes.Buffer
+	opts := Options{Writers: map[string]io.Writer{"json": &jsonOut, "markdown": &markdownOut}, Log: &log}
+	opts.Dir = dir
+	opts.Pkg = "main"
+	opts.PrintPrivate = true
+	opts.Range = commits[0].String() + "..HEAD"
+
+	report, err := RunRange(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Commits) != 2 {
+		t.Fatalf("got %d commits, want 2", len(report.Commits))
+	}
+	for i, c := range report.Commits {
+		if c.Hash != commits[i+1].String() || c.Parent != commits[i].String() {
+			t.Errorf("commit %d is %s (parent %s), want %s (parent %s)", i, c.Hash, c.Parent, commits[i+1], commits[i])
+		}
+	}
+	if got := report.Commits[1].ChangeList.New; len(got) != 1 || got[0] != "main.twice" {
+		t.Errorf("new functions of %s = %v, want only twice", commits[2], got)
+	}
+	if jsonOut.Len() == 0 || markdownOut.Len() == 0 {
+		t.Errorf("range report was not written: json %d bytes, markdown %d bytes", jsonOut.Len(), markdownOut.Len())
+	}
+	if !strings.Contains(log.String(), "analysing "+commits[2].String()) {
+		t.Errorf("progress was not logged:\n%s", log.String())
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	dir, commits := newFixture(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	opts := Options{Old: "HEAD^", New: "HEAD", Writers: map[string]io.Writer{"json": ioutil.Discard}}
+	opts.Dir = dir
+	opts.Pkg = "main"
+	if _, err := Run(ctx, opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run with a cancelled context returned %v, want context.Canceled", err)
+	}
+
+	opts.Range = commits[0].String() + "..HEAD"
+	if _, err := RunRange(ctx, opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("RunRange with a cancelled context returned %v, want context.Canceled", err)
+	}
+}
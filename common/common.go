@@ -1,29 +1,83 @@
 package common
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
-
-	"golang.org/x/tools/go/callgraph"
 )
 
 // GraphOptions 函数调用图相关选项
 type GraphOptions struct {
-	Commit    string
-	CallGraph *callgraph.Graph
-	TempPath  string
+	Commit   string
+	Hash     string // Commit 解析出的提交 hash，工作区与暂存区为空
+	TempPath string
+	Module   string // 快照的模块路径，用于解析 --pkg 中相对于模块根目录的模式
 }
 
 // DiffOptions 差异输出相关选项
 type DiffOptions struct {
-	URL            string
-	Dir            string
-	Test           bool
-	PrintPrivate   bool
-	PrintUnchanged bool
-	Pkg            string
-	Output         string
+	URL                  string
+	Dir                  string
+	BaseMode             string
+	Range                string
+	FirstParent          bool
+	Test                 bool
+	PrintPrivate         bool
+	PrintUnchanged       bool
+	Pkg                  string // 逗号分隔的包模式，见 ParsePkgFilter
+	Algo                 string
+	HashMode             string // 函数指纹的计算方式：ssa、normalized、ast 或 source
+	SeparateClosures     bool   // 闭包作为单独的函数输出，默认合并到其最外层的函数中
+	ReportInstantiations bool   // 输出泛型函数的实例的变化
+	MaxDistance          int    // 只报告距离代码改变的函数不超过该跳数的受影响函数，0 表示不限制
+	PathRoots            string // 逗号分隔的调用链入口函数的种类：main、exported、handlers、tests
+	PathCount            int    // 为每个改变的函数输出的最短调用链的条数，0 表示不输出
+	HighlightPaths       bool   // 在 SVG 中突出显示调用链
+	Renderer             string // SVG 的渲染方式：auto、dot 或 builtin，为空时视为 auto
+	MarkdownBudget       int    // Markdown 报告的字符数上限，0 表示不限制
+	CacheDir             string // 调用图缓存目录，为空时不使用缓存
+	Output               string
+}
+
+// 不同类型的失败对应不同的退出码
+const (
+	ExitGitError      = 2 // 打开仓库、解析 revision、输出快照失败
+	ExitLoadError     = 3 // 加载包失败，或没有匹配 --pkg 的包
+	ExitAnalysisError = 4 // 构建调用图或比较差异失败
+	ExitOutputError   = 5 // 输出结果失败
+)
+
+// ExitError 带有退出码的错误
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string { return e.Err.Error() }
+
+func (e *ExitError) Unwrap() error { return e.Err }
+
+// WrapError 为 err 标记上退出码，err 为 nil 时返回 nil
+func WrapError(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return err
+	}
+	return &ExitError{Code: code, Err: err}
+}
+
+// ExitCode 返回 err 对应的退出码，未标记的错误退出码为 1
+func ExitCode(err error) int {
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	return 1
 }
 
 // CheckArgs should be used to ensure the right command line arguments are
@@ -41,8 +95,13 @@ func CheckIfError(err error) {
 		return
 	}
 
+	PrintError(err)
+	os.Exit(ExitCode(err))
+}
+
+// PrintError should be used to display a fatal error
+func PrintError(err error) {
 	fmt.Printf("\x1b[31;1m%s\x1b[0m\n", fmt.Sprintf("error: %s", err))
-	os.Exit(1)
 }
 
 // Info should be used to describe the example commands that are about to run.
@@ -55,6 +114,17 @@ func Warning(format string, args ...interface{}) {
 	fmt.Printf("\x1b[36;1m%s\x1b[0m\n", fmt.Sprintf(format, args...))
 }
 
+// WarningPrefix 库通过 Logf 输出的警告信息的前缀
+const WarningPrefix = "warning: "
+
+// Logf 将一行提示信息写入 w，w 为 nil 时丢弃。库代码使用它代替 Info 与 Warning，由调用方决定信息的去向
+func Logf(w io.Writer, format string, args ...interface{}) {
+	if w == nil {
+		return
+	}
+	_, _ = fmt.Fprintf(w, format+"\n", args...)
+}
+
 // Error should be used to display an error
 func Error(format string, args ...interface{}) {
 	fmt.Printf("\x1b[36;1m%s\x1b[0m\n", fmt.Sprintf(format, args...))
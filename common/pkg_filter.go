@@ -0,0 +1,88 @@
+package common
+
+import (
+	"regexp"
+	"strings"
+)
+
+// PkgFilter 按照 --pkg 选择要分析与输出的包
+type PkgFilter struct {
+	spec    string
+	include []string
+	exclude []string
+	module  string
+}
+
+// ParsePkgFilter 解析 --pkg，多个模式以逗号分隔，以 - 开头的为排除模式。模式可以是：
+//   - 包名，如 main（没有 / 与 . 时，同时匹配包名与导入路径）
+//   - 导入路径，... 匹配任意字符串，如 github.com/org/repo/api/...
+//   - 以 ./ 开头、相对于模块根目录的路径，如 ./internal/...，需要通过 WithModule 指定模块路径
+func ParsePkgFilter(spec string) *PkgFilter {
+	f := &PkgFilter{spec: spec}
+	for _, pattern := range strings.Split(spec, ",") {
+		pattern = strings.TrimSpace(pattern)
+		switch {
+		case pattern == "", pattern == "-":
+		case strings.HasPrefix(pattern, "-"):
+			f.exclude = append(f.exclude, pattern[1:])
+		default:
+			f.include = append(f.include, pattern)
+		}
+	}
+	return f
+}
+
+// WithModule 返回以 module 为模块路径解析相对模式的 PkgFilter
+func (f *PkgFilter) WithModule(module string) *PkgFilter {
+	g := *f
+	g.module = module
+	return &g
+}
+
+// String 返回原始的 --pkg
+func (f *PkgFilter) String() string {
+	return f.spec
+}
+
+// Match 判断导入路径为 path、包名为 name 的包是否被选中
+func (f *PkgFilter) Match(path string, name string) bool {
+	matched := false
+	for _, pattern := range f.include {
+		if f.matchPattern(pattern, path, name) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return false
+	}
+	for _, pattern := range f.exclude {
+		if f.matchPattern(pattern, path, name) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *PkgFilter) matchPattern(pattern string, path string, name string) bool {
+	if pattern == "." || strings.HasPrefix(pattern, "./") {
+		if f.module == "" {
+			return false
+		}
+		pattern = f.module + strings.TrimPrefix(pattern, ".")
+	} else if !strings.ContainsAny(pattern, "/.") {
+		return pattern == name || pattern == path
+	}
+	return matchPath(pattern, path)
+}
+
+// matchPath 与 go 命令的包模式一致：... 匹配任意字符串，a/... 同时匹配 a 本身
+func matchPath(pattern string, path string) bool {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.ReplaceAll(re, `\.\.\.`, `.*`)
+	if strings.HasSuffix(re, `/.*`) {
+		re = strings.TrimSuffix(re, `/.*`) + `(/.*)?`
+	}
+	matched, _ := regexp.MatchString("^"+re+"$", path)
+	return matched
+}
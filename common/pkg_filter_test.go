@@ -0,0 +1,43 @@
+package common
+
+import "testing"
+
+func TestPkgFilter(t *testing.T) {
+	const module = "github.com/org/repo"
+	cases := []struct {
+		spec string
+		path string
+		name string
+		want bool
+	}{
+		{"main", module, "main", true},
+		{"main", module + "/cmd/tool", "main", true},
+		{"main", module + "/server", "server", false},
+		{"server", module + "/server", "server", true},
+		{"server", module + "/internal/server", "server", true},
+		{"./internal/...", module + "/internal", "internal", true},
+		{"./internal/...", module + "/internal/server", "server", true},
+		{"./internal/...", module + "/internalx", "internalx", false},
+		{"./api", module + "/api", "api", true},
+		{"./api", module + "/api/v1", "v1", false},
+		{".", module, "repo", true},
+		{module + "/api/...", module + "/api/v1", "v1", true},
+		{module + "/api/...", module + "/server", "server", false},
+		{"./...,-./internal/...", module + "/internal/server", "server", false},
+		{"./...,-./internal/...", module + "/api", "api", true},
+		{"./api, ./server", module + "/server", "server", true},
+		{"./...,-main", module + "/cmd/tool", "main", false},
+		{"github.com/.../v1", module + "/api/v1", "v1", true},
+	}
+	for _, c := range cases {
+		f := ParsePkgFilter(c.spec).WithModule(module)
+		if got := f.Match(c.path, c.name); got != c.want {
+			t.Errorf("ParsePkgFilter(%q).Match(%q, %q) = %v, want %v", c.spec, c.path, c.name, got, c.want)
+		}
+	}
+
+	// 未指定模块路径时，相对模式不匹配任何包
+	if ParsePkgFilter("./...").Match(module+"/api", "api") {
+		t.Error("relative pattern should not match without module")
+	}
+}
@@ -1,50 +1,107 @@
 package graph
 
 import (
+	"context"
 	"fmt"
+	"go/build"
 	"go/token"
-	"io/ioutil"
 	"os"
 	"regexp"
-	"sync"
+	"runtime"
+	"sort"
+	"strings"
 	"unicode"
 
 	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/callgraph/cha"
 	"golang.org/x/tools/go/callgraph/rta"
+	"golang.org/x/tools/go/callgraph/static"
+	"golang.org/x/tools/go/callgraph/vta"
 	"golang.org/x/tools/go/packages"
 	"golang.org/x/tools/go/ssa"
 	"golang.org/x/tools/go/ssa/ssautil"
 
+	"github.com/bytecamp2021-calldiff/calldiff/analyze"
+	"github.com/bytecamp2021-calldiff/calldiff/cache"
 	"github.com/bytecamp2021-calldiff/calldiff/common"
 )
 
-func GetCallGraph(diffOptions *common.DiffOptions, graphOptions *common.GraphOptions, wg *sync.WaitGroup) {
-	defer wg.Done()
-
-	r := clone(diffOptions.URL, diffOptions.Dir)
+// LoadError 加载包失败时的诊断信息
+type LoadError struct {
+	Diagnostics []string
+}
 
-	commitHash := getCommitHash(r, graphOptions.Commit)
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("packages contain errors:\n\t%s", strings.Join(e.Diagnostics, "\n\t"))
+}
 
-	path, err := ioutil.TempDir(diffOptions.Dir, "")
+// GetCallGraph 构建 graphOptions.Commit 对应的函数调用图，两侧可以共享同一个 *Repository 并发调用。
+// 指定了 diffOptions.CacheDir 时，提交的调用图会被缓存，命中缓存时不再加载包与构建 SSA；
+// 返回的错误带有 common.ExitGitError、common.ExitLoadError 或 common.ExitAnalysisError 退出码
+func GetCallGraph(ctx context.Context, r *Repository, diffOptions *common.DiffOptions, graphOptions *common.GraphOptions) (*analyze.Graph, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	hash, err := r.resolve(graphOptions.Commit)
 	if err != nil {
-		common.Error("%s", err)
-		return
+		return nil, common.WrapError(common.ExitGitError, err)
 	}
+	graphOptions.Hash = hash
 
-	graphOptions.TempPath = path
+	// 工作区与暂存区没有对应的提交，不使用缓存
+	c := &cache.Cache{Dir: diffOptions.CacheDir}
+	key := CacheKey(diffOptions, hash)
+	cacheable := diffOptions.CacheDir != "" && hash != ""
+	if cacheable {
+		if g, ok := c.Load(key); ok {
+			common.Logf(r.log, "use cached call graph of %s (%s)", graphOptions.Commit, hash)
+			graphOptions.Module = g.Module()
+			return g, nil
+		}
+	}
+
+	if err := r.extract(graphOptions); err != nil {
+		return nil, common.WrapError(common.ExitGitError, err)
+	}
 
 	defer func(path string) {
 		_ = os.RemoveAll(path)
 	}(graphOptions.TempPath)
 
-	if err := outputCommitFiles(commitHash, graphOptions.TempPath); err != nil {
-		common.Error("%s", err)
-		return
+	g, err := doCallGraph(ctx, diffOptions, graphOptions)
+	if err != nil {
+		return nil, err
+	}
+	if cacheable {
+		if err := c.Store(key, g); err != nil {
+			common.Logf(r.log, common.WarningPrefix+"failed to cache call graph of %s: %s", graphOptions.Commit, err)
+		}
 	}
+	return g, nil
+}
 
-	if err := doCallGraph(diffOptions, graphOptions); err != nil {
-		common.Error("%s", err)
-		return
+// CacheKey 返回提交 hash 在当前构建配置下的缓存 key
+func CacheKey(diffOptions *common.DiffOptions, hash string) cache.Key {
+	tags := append([]string(nil), build.Default.BuildTags...)
+	sort.Strings(tags)
+	algo := diffOptions.Algo
+	if algo == "" {
+		algo = AlgoRTA
+	}
+	hashMode := diffOptions.HashMode
+	if hashMode == "" {
+		hashMode = analyze.HashSSA
+	}
+	return cache.Key{
+		Commit:    hash,
+		GoVersion: runtime.Version(),
+		Tags:      tags,
+		Algo:      algo,
+		Hash:      hashMode,
+		Closures:  diffOptions.SeparateClosures,
+		Test:      diffOptions.Test,
+		Pkg:       diffOptions.Pkg,
+		Private:   diffOptions.PrintPrivate,
 	}
 }
 
@@ -67,31 +124,55 @@ func getAllFunctions(s *ssa.Package) *[]*ssa.Function {
 	return &result
 }
 
-func doCallGraph(diffOptions *common.DiffOptions, graphOptions *common.GraphOptions) error {
+func doCallGraph(ctx context.Context, diffOptions *common.DiffOptions, graphOptions *common.GraphOptions) (*analyze.Graph, error) {
 	cfg := &packages.Config{
+		Context: ctx,
 		Mode: packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles |
 			packages.NeedImports | packages.NeedTypes | packages.NeedTypesSizes |
-			packages.NeedSyntax | packages.NeedTypesInfo,
+			packages.NeedSyntax | packages.NeedTypesInfo | packages.NeedModule,
 		Tests: diffOptions.Test,
 		Dir:   graphOptions.TempPath,
 	}
+	if len(build.Default.BuildTags) > 0 {
+		cfg.BuildFlags = []string{"-tags=" + strings.Join(build.Default.BuildTags, ",")}
+	}
 	initial, err := packages.Load(cfg, "./...")
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if err != nil {
-		return err
+		return nil, common.WrapError(common.ExitLoadError, err)
 	}
-	if packages.PrintErrors(initial) > 0 {
-		return fmt.Errorf("packages contain errors")
+	var diagnostics []string
+	packages.Visit(initial, nil, func(p *packages.Package) {
+		for _, e := range p.Errors {
+			diagnostics = append(diagnostics, e.Error())
+		}
+	})
+	if len(diagnostics) > 0 {
+		return nil, common.WrapError(common.ExitLoadError, &LoadError{Diagnostics: diagnostics})
 	}
 
 	// Create and build SSA-form program representation.
-	prog, pkgs := ssautil.Packages(initial, 0)
+	// 构建泛型函数的实例，调用图中实例内部的调用按具体类型解析，比较时实例再合并到其泛型函数上
+	prog, pkgs := ssautil.Packages(initial, ssa.InstantiateGenerics)
 	prog.Build()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	// -- call graph construction ------------------------------------------
 
-	mains, err := mainPackages(pkgs, diffOptions.Pkg)
+	for _, p := range initial {
+		if p.Module != nil {
+			graphOptions.Module = p.Module.Path
+			break
+		}
+	}
+	filter := common.ParsePkgFilter(diffOptions.Pkg).WithModule(graphOptions.Module)
+	mains, err := mainPackages(pkgs, filter)
 	if err != nil {
-		return err
+		return nil, common.WrapError(common.ExitLoadError, err)
 	}
 	var roots []*ssa.Function
 	for _, main := range mains {
@@ -105,26 +186,96 @@ func doCallGraph(diffOptions *common.DiffOptions, graphOptions *common.GraphOpti
 			}
 		}
 	}
-	rtares := rta.Analyze(roots, true)
-	graphOptions.CallGraph = rtares.CallGraph
+	cg, err := buildCallGraph(prog, roots, diffOptions.Algo)
+	if err != nil {
+		return nil, common.WrapError(common.ExitAnalysisError, err)
+	}
+	consts := make(analyze.ConstUses)
+	for _, p := range initial {
+		consts.Add(p.TypesInfo)
+	}
+	g, err := analyze.NewGraph(cg, graphOptions.TempPath, graphOptions.Module, diffOptions, consts)
+	if err != nil {
+		return nil, common.WrapError(common.ExitAnalysisError, err)
+	}
+	return g, nil
+}
+
+// 支持的调用图构建算法
+const (
+	AlgoStatic = "static" // 只包含静态调用
+	AlgoCHA    = "cha"    // Class Hierarchy Analysis，接口调用指向所有实现
+	AlgoRTA    = "rta"    // Rapid Type Analysis，接口调用指向从根函数可达的实现
+	AlgoVTA    = "vta"    // Variable Type Analysis，按变量可能持有的类型确定接口调用
+)
+
+// AlgoPointer 指针分析，golang.org/x/tools 已不再提供，指定时报错并提示改用 vta
+const AlgoPointer = "pointer"
+
+// Algorithms 支持的调用图构建算法
+var Algorithms = []string{AlgoStatic, AlgoCHA, AlgoRTA, AlgoVTA}
+
+// CheckAlgo 检查调用图构建算法是否受支持，为空时视为 rta
+func CheckAlgo(algo string) error {
+	if algo == "" {
+		return nil
+	}
+	for _, a := range Algorithms {
+		if a == algo {
+			return nil
+		}
+	}
+	if algo == AlgoPointer {
+		return fmt.Errorf("call graph algorithm %q is no longer available, golang.org/x/tools has removed pointer analysis, use %s instead", algo, AlgoVTA)
+	}
+	return fmt.Errorf("unsupported call graph algorithm %q, supported are %s", algo, strings.Join(Algorithms, ", "))
+}
 
-	// NB: RTA gives us Reachable and RuntimeTypes too.
+// buildCallGraph 按照 algo 构建调用图，rta 以 roots 为根
+func buildCallGraph(prog *ssa.Program, roots []*ssa.Function, algo string) (*callgraph.Graph, error) {
+	if err := CheckAlgo(algo); err != nil {
+		return nil, err
+	}
+	var cg *callgraph.Graph
+	switch algo {
+	case AlgoStatic:
+		cg = staticCallGraph(prog)
+	case AlgoCHA:
+		cg = cha.CallGraph(prog)
+	case "", AlgoRTA:
+		if len(roots) == 0 {
+			return nil, fmt.Errorf("no root functions for rta")
+		}
+		// NB: RTA gives us Reachable and RuntimeTypes too.
+		cg = rta.Analyze(roots, true).CallGraph
+	case AlgoVTA:
+		cg = vta.CallGraph(ssautil.AllFunctions(prog), cha.CallGraph(prog))
+	}
+	cg.DeleteSyntheticNodes()
+	return cg, nil
+}
 
-	graphOptions.CallGraph.DeleteSyntheticNodes()
-	return nil
+// staticCallGraph 只包含静态调用的调用图。
+// 与 golang.org/x/tools 早期版本的 static.CallGraph 一样包含程序中的所有函数，
+// 新版本只包含从包级函数与方法出发经静态调用可达的函数，没有只作为函数值使用的闭包
+func staticCallGraph(prog *ssa.Program) *callgraph.Graph {
+	cg := static.CallGraph(prog)
+	for f := range ssautil.AllFunctions(prog) {
+		cg.CreateNode(f)
+	}
+	return cg
 }
 
-// mainPackages returns the main packages to analyze.
-// Each resulting package is named "main" and has a main function.
-func mainPackages(pkgs []*ssa.Package, pkg string) ([]*ssa.Package, error) {
+// mainPackages returns the packages matching --pkg, their functions are the roots of the call graph.
+func mainPackages(pkgs []*ssa.Package, filter *common.PkgFilter) ([]*ssa.Package, error) {
 	var mains []*ssa.Package
 	for _, p := range pkgs {
-		if p != nil && p.Pkg.Name() == pkg {
+		if p != nil && filter.Match(p.Pkg.Path(), p.Pkg.Name()) {
 			mains = append(mains, p)
 		}
 	}
 	if len(mains) == 0 {
-		return nil, fmt.Errorf("no %s packages", pkg)
+		return nil, fmt.Errorf("no packages match %q", filter)
 	}
 	return mains, nil
 }
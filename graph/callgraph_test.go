@@ -0,0 +1,107 @@
+package graph
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// 接口调用的测试用例：B 只在 other 中被转换为接口，不会流向 call 的参数
+const interfaceFixture = `package main
+
+type I interface{ F() }
+
+type A struct{}
+
+func (A) F() {}
+
+type B struct{}
+
+func (B) F() {}
+
+type C struct{}
+
+func (C) F() {}
+
+func call(i I) { i.F() }
+
+func other() {
+	var j I = B{}
+	_ = j
+}
+
+func main() {
+	call(A{})
+	other()
+}
+`
+
+func buildFixture(t *testing.T, src string) *ssa.Package {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "main.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, _, err := ssautil.BuildPackage(&types.Config{Importer: importer.Default()}, fset,
+		types.NewPackage("example.com/fixture", "main"), []*ast.File{f}, ssa.SanityCheckFunctions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkg
+}
+
+// calleesOf 返回调用图中 caller 调用的函数
+func calleesOf(cg *callgraph.Graph, caller string) string {
+	var callees []string
+	for fn, node := range cg.Nodes {
+		if fn == nil || fn.Pkg == nil || fn.RelString(fn.Pkg.Pkg) != caller {
+			continue
+		}
+		for _, edge := range node.Out {
+			callees = append(callees, edge.Callee.Func.RelString(fn.Pkg.Pkg))
+		}
+	}
+	sort.Strings(callees)
+	return strings.Join(callees, " ")
+}
+
+func TestBuildCallGraph(t *testing.T) {
+	expect := map[string]string{
+		AlgoStatic: "",
+		AlgoCHA:    "(A).F (B).F (C).F",
+		AlgoRTA:    "(A).F (B).F",
+		AlgoVTA:    "(A).F",
+	}
+	for _, algo := range Algorithms {
+		pkg := buildFixture(t, interfaceFixture)
+		roots := []*ssa.Function{pkg.Func("main")}
+		cg, err := buildCallGraph(pkg.Prog, roots, algo)
+		if err != nil {
+			t.Fatalf("%s: %v", algo, err)
+		}
+		if got := calleesOf(cg, "call"); got != expect[algo] {
+			t.Errorf("%s: call -> [%s], want [%s]", algo, got, expect[algo])
+		}
+		if got := calleesOf(cg, "main"); got != "call other" {
+			t.Errorf("%s: main -> [%s], want [call other]", algo, got)
+		}
+	}
+
+	pkg := buildFixture(t, interfaceFixture)
+	if _, err := buildCallGraph(pkg.Prog, nil, "andersen"); err == nil {
+		t.Error("unsupported algorithm should fail")
+	}
+	// pointer 已不再提供，错误信息中提示改用 vta
+	if _, err := buildCallGraph(pkg.Prog, nil, AlgoPointer); err == nil || !strings.Contains(err.Error(), AlgoVTA) {
+		t.Errorf("pointer should fail and point to %s, got %v", AlgoVTA, err)
+	}
+}
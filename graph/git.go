@@ -1,66 +1,341 @@
 package graph
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
 
 	"github.com/bytecamp2021-calldiff/calldiff/common"
 )
 
+const (
+	// Worktree 表示分析工作区中（含未暂存修改）的代码
+	Worktree = "WORKTREE"
+	// Index 表示分析暂存区中的代码
+	Index = "INDEX"
+)
+
+const (
+	// BaseModeDirect 直接比较 old 与 new
+	BaseModeDirect = "direct"
+	// BaseModeMergeBase 以 old 与 new 的 merge base 作为旧版本，与 GitHub/GitLab 展示 PR 差异的方式一致
+	BaseModeMergeBase = "merge-base"
+)
+
+// snapshotPrefix 输出快照的临时目录前缀，分析工作区时需要跳过这些目录
+const snapshotPrefix = ".calldiff-"
+
+// Repository 两侧快照共享的仓库。
+// go-git 读取对象时会修改内部缓存，并不是并发安全的，所有 git 操作都需要在 mu 的保护下进行
+type Repository struct {
+	repo *git.Repository
+	dir  string
+	log  io.Writer // 提示信息的去向，为 nil 时丢弃
+	mu   sync.Mutex
+}
+
+// OpenRepository 打开 dir 处的仓库，dir 不存在且 url 非空时先 clone 到 dir。
+// clone、ResolveBase 与 GetCallGraph 的提示信息写入 log，log 为 nil 时丢弃
+func OpenRepository(ctx context.Context, url, dir string, log io.Writer) (*Repository, error) {
+	r, err := clone(ctx, url, dir, log)
+	if err != nil {
+		return nil, common.WrapError(common.ExitGitError, err)
+	}
+	return &Repository{repo: r, dir: dir, log: log}, nil
+}
+
 // Clone a repository using clone options
-func clone(url, dir string) *git.Repository {
+func clone(ctx context.Context, url, dir string, log io.Writer) (*git.Repository, error) {
 	// check whether it's necessary to clone git repo
 	if _, err := os.Stat(dir); os.IsNotExist(err) && url != "" {
 		// Clone the given repository to the given directory
-		common.Info("git clone %s %s --recursive", url, dir)
+		common.Logf(log, "git clone %s %s --recursive", url, dir)
 
-		r, err := git.PlainClone(dir, false, &git.CloneOptions{
+		return git.PlainCloneContext(ctx, dir, false, &git.CloneOptions{
 			URL:               url,
 			RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
 		})
-		common.CheckIfError(err)
+	}
+
+	return git.PlainOpen(dir)
+}
+
+// resolve 返回 rev 对应的提交 hash，工作区与暂存区返回空字符串
+func (r *Repository) resolve(rev string) (string, error) {
+	if rev == Worktree || rev == Index {
+		return "", nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	commit, err := resolveCommit(r.repo, rev)
+	if err != nil {
+		return "", err
+	}
+	return commit.Hash.String(), nil
+}
+
+// extract 将 graphOptions.Commit 对应的源码快照输出到仓库目录下的临时目录中，并记录在 graphOptions.TempPath，
+// 调用方负责在分析结束后删除该目录
+func (r *Repository) extract(graphOptions *common.GraphOptions) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snap, err := getSnapshot(r.repo, graphOptions.Commit)
+	if err != nil {
+		return err
+	}
+	path, err := ioutil.TempDir(r.dir, snapshotPrefix)
+	if err != nil {
+		return err
+	}
+	if err := snap(path); err != nil {
+		_ = os.RemoveAll(path)
+		return err
+	}
+	graphOptions.TempPath = path
+	return nil
+}
+
+// resolveCommit 按照 git 的 revision 语法解析出对应的 commit，
+// 支持分支、标签、短 hash、HEAD~3、HEAD^2、origin/main、v1.2.0^{commit} 等写法，
+// 其中 HEAD^ 与 git 一致，取的是第一个父提交而不是按提交时间排序的前一个提交
+func resolveCommit(r *git.Repository, rev string) (*object.Commit, error) {
+	// 与 git 一致，名称同时是引用与短 hash 时优先使用引用，go-git 则优先使用短 hash
+	name, suffix := splitRevision(rev)
+	if ref, ok := lookupRef(r, name); ok {
+		rev = ref.String() + suffix
+	} else if err := checkAmbiguous(r, name); err != nil {
+		return nil, err
+	}
+	hash, err := r.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, fmt.Errorf("unknown revision %q: %w", rev, err)
+	}
+	commit, err := r.CommitObject(*hash)
+	if err != nil {
+		return nil, fmt.Errorf("revision %q does not point to a commit: %w", rev, err)
+	}
+	return commit, nil
+}
 
-		return r
+// splitRevision 将 revision 拆分为开头的名称与其后的 ^、~ 等后缀，如 v1.2.0~2 拆分为 v1.2.0 与 ~2
+func splitRevision(rev string) (string, string) {
+	if i := strings.IndexAny(rev, "^~@:"); i >= 0 {
+		return rev[:i], rev[i:]
 	}
+	return rev, ""
+}
 
-	r, err := git.PlainOpen(dir)
-	common.CheckIfError(err)
+// lookupRef 按 git 的规则（name、refs/name、refs/tags/name、refs/heads/name 等）查找名为 name 的引用
+func lookupRef(r *git.Repository, name string) (plumbing.Hash, bool) {
+	if name == "" {
+		return plumbing.ZeroHash, false
+	}
+	for _, rule := range append([]string{"%s"}, plumbing.RefRevParseRules...) {
+		ref, err := storer.ResolveReference(r.Storer, plumbing.ReferenceName(fmt.Sprintf(rule, name)))
+		if err == nil {
+			return ref.Hash(), true
+		}
+	}
+	return plumbing.ZeroHash, false
+}
+
+// checkAmbiguous 检查作为 revision 开头的短 hash 是否能匹配到多个 commit，
+// go-git 在这种情况下会静默地取第一个，这里和 git 一样直接报错
+func checkAmbiguous(r *git.Repository, prefix string) error {
+	if len(prefix) < 4 || len(prefix) >= 2*len(plumbing.ZeroHash) || !isHex(prefix) {
+		return nil
+	}
+	commits, err := r.CommitObjects()
+	if err != nil {
+		return err
+	}
+	var candidates []string
+	_ = commits.ForEach(func(c *object.Commit) error {
+		if strings.HasPrefix(c.Hash.String(), strings.ToLower(prefix)) {
+			candidates = append(candidates, c.Hash.String())
+		}
+		return nil
+	})
+	if len(candidates) > 1 {
+		sort.Strings(candidates)
+		return fmt.Errorf("ambiguous revision %q, candidates are: %s", prefix, strings.Join(candidates, ", "))
+	}
+	return nil
+}
 
-	return r
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
 }
 
-func getCommitHash(r *git.Repository, s string) *object.Commit {
-	var hash plumbing.Hash
-	head, err := r.Head()
-	common.CheckIfError(err)
-	if s == "HEAD" {
-		hash = head.Hash()
-	} else if s == "HEAD^" {
-		ref, err := r.CommitObject(head.Hash())
-		common.CheckIfError(err)
+// ResolveBase 按照 diffOptions.BaseMode 确定旧版本，merge-base 模式下将 source.Commit 替换为 merge base
+func ResolveBase(r *Repository, diffOptions *common.DiffOptions, source *common.GraphOptions, target *common.GraphOptions) error {
+	switch diffOptions.BaseMode {
+	case "", BaseModeDirect:
+		return nil
+	case BaseModeMergeBase:
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		base, err := mergeBase(r.repo, source.Commit, target.Commit, r.log)
+		if err != nil {
+			return common.WrapError(common.ExitGitError, err)
+		}
+		common.Logf(r.log, "merge base of %s and %s is %s", source.Commit, target.Commit, base.Hash)
+		source.Commit = base.Hash.String()
+		return nil
+	default:
+		return fmt.Errorf("unsupported base mode %q", diffOptions.BaseMode)
+	}
+}
 
-		commitIter := object.NewCommitIterCTime(ref, nil, nil)
+// mergeBase 求 oldRev 与 newRev 的 merge base，newRev 为工作区或暂存区时使用 HEAD，
+// 存在多个 merge base 时向 log 输出警告
+func mergeBase(r *git.Repository, oldRev string, newRev string, log io.Writer) (*object.Commit, error) {
+	if newRev == Worktree || newRev == Index {
+		newRev = "HEAD"
+	}
+	oldCommit, err := resolveCommit(r, oldRev)
+	if err != nil {
+		return nil, err
+	}
+	newCommit, err := resolveCommit(r, newRev)
+	if err != nil {
+		return nil, err
+	}
+	bases, err := oldCommit.MergeBase(newCommit)
+	if err != nil {
+		return nil, err
+	}
+	if len(bases) == 0 {
+		return nil, fmt.Errorf("%s and %s have no common ancestor", oldRev, newRev)
+	}
+	if len(bases) > 1 {
+		common.Logf(log, common.WarningPrefix+"%s and %s have %d merge bases, using %s", oldRev, newRev, len(bases), bases[0].Hash)
+	}
+	return bases[0], nil
+}
 
-		_, err = commitIter.Next() // at HEAD
-		common.CheckIfError(err)
+// RangeCommits 解析 diffOptions.Range（形如 v1.4.0..v1.5.0），按从旧到新的顺序返回范围内的提交，
+// 即从右端可达而从左端不可达的提交；FirstParent 为 true 时只沿第一个父提交回溯
+func RangeCommits(r *Repository, diffOptions *common.DiffOptions) ([]*object.Commit, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	commits, err := rangeCommits(r.repo, diffOptions.Range, diffOptions.FirstParent)
+	return commits, common.WrapError(common.ExitGitError, err)
+}
 
-		commit, err := commitIter.Next() // at HEAD^
-		common.CheckIfError(err)
+func rangeCommits(r *git.Repository, spec string, firstParent bool) ([]*object.Commit, error) {
+	sides := strings.Split(spec, "..")
+	if len(sides) != 2 || sides[0] == "" || sides[1] == "" || strings.HasPrefix(sides[1], ".") {
+		return nil, fmt.Errorf("invalid range %q, expected <from>..<to>", spec)
+	}
+	from, err := resolveCommit(r, sides[0])
+	if err != nil {
+		return nil, err
+	}
+	to, err := resolveCommit(r, sides[1])
+	if err != nil {
+		return nil, err
+	}
+	// 左端可达的提交都不在范围内
+	excluded := make(map[plumbing.Hash]struct{})
+	fromIter, err := r.Log(&git.LogOptions{From: from.Hash})
+	if err != nil {
+		return nil, err
+	}
+	err = fromIter.ForEach(func(c *object.Commit) error {
+		excluded[c.Hash] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		hash = commit.Hash
+	var commits []*object.Commit
+	if firstParent {
+		for c := to; ; {
+			if _, ok := excluded[c.Hash]; ok {
+				break
+			}
+			commits = append(commits, c)
+			if c.NumParents() == 0 {
+				break
+			}
+			if c, err = c.Parent(0); err != nil {
+				return nil, err
+			}
+		}
 	} else {
-		hash = plumbing.NewHash(s)
+		toIter, err := r.Log(&git.LogOptions{From: to.Hash, Order: git.LogOrderCommitterTime})
+		if err != nil {
+			return nil, err
+		}
+		err = toIter.ForEach(func(c *object.Commit) error {
+			if _, ok := excluded[c.Hash]; !ok {
+				commits = append(commits, c)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	// 翻转为从旧到新
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
 	}
-	commit, err := r.CommitObject(hash)
-	common.CheckIfError(err)
-	return commit
+	return commits, nil
+}
+
+// snapshot 将一份源码快照（某个 commit、暂存区或工作区）输出到 dir 中
+type snapshot func(dir string) error
+
+// getSnapshot 根据 revision 得到对应的源码快照，
+// 除普通的 git revision 外，还支持 WORKTREE（工作区）和 INDEX（暂存区）
+func getSnapshot(r *git.Repository, rev string) (snapshot, error) {
+	switch rev {
+	case Worktree:
+		return func(dir string) error { return outputWorktreeFiles(r, dir) }, nil
+	case Index:
+		return func(dir string) error { return outputIndexFiles(r, dir) }, nil
+	}
+	commit, err := resolveCommit(r, rev)
+	if err != nil {
+		return nil, err
+	}
+	return func(dir string) error { return outputCommitFiles(commit, dir) }, nil
+}
+
+// isSourceFile 判断文件是否为分析所需的文件
+func isSourceFile(name string) bool {
+	return name == "go.mod" || name == "go.sum" || strings.HasSuffix(name, ".go")
+}
+
+func writeSourceFile(dir string, name string, contents []byte) error {
+	filePath := dir + "/" + name
+	if err := os.MkdirAll(filepath.Dir(filePath), fs.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, contents, fs.ModePerm)
 }
 
 func outputCommitFiles(commit *object.Commit, dir string) error {
@@ -73,20 +348,95 @@ func outputCommitFiles(commit *object.Commit, dir string) error {
 		if err != nil { // err 只可能等于 nil 或 io.EOF
 			break
 		}
-		if file.Name != "go.mod" && file.Name != "go.sum" && !strings.HasSuffix(file.Name, ".go") {
+		if !isSourceFile(file.Name) {
 			continue
 		}
 		contents, err := file.Contents()
 		if err != nil {
 			return err
 		}
-		filePath := dir + "/" + file.Name
-		if err := os.MkdirAll(filepath.Dir(filePath), fs.ModePerm); err != nil {
+		if err := writeSourceFile(dir, file.Name, []byte(contents)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outputIndexFiles 输出暂存区中的文件
+func outputIndexFiles(r *git.Repository, dir string) error {
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return err
+	}
+	for _, entry := range idx.Entries {
+		if entry.Mode == filemode.Submodule || !isSourceFile(entry.Name) {
+			continue
+		}
+		blob, err := r.BlobObject(entry.Hash)
+		if err != nil {
+			return err
+		}
+		reader, err := blob.Reader()
+		if err != nil {
+			return err
+		}
+		contents, err := ioutil.ReadAll(reader)
+		_ = reader.Close()
+		if err != nil {
 			return err
 		}
-		if err := ioutil.WriteFile(filePath, []byte(contents), fs.ModePerm); err != nil {
+		if err := writeSourceFile(dir, entry.Name, contents); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// outputWorktreeFiles 输出工作区中的文件，未被跟踪且被 .gitignore 忽略的文件不会输出
+func outputWorktreeFiles(r *git.Repository, dir string) error {
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	patterns, err := gitignore.ReadPatterns(w.Filesystem, nil)
+	if err != nil {
+		return err
+	}
+	matcher := gitignore.NewMatcher(append(patterns, w.Excludes...))
+	// 已跟踪的文件即使匹配 .gitignore 也需要输出
+	tracked := make(map[string]struct{})
+	if idx, err := r.Storer.Index(); err == nil {
+		for _, entry := range idx.Entries {
+			tracked[entry.Name] = struct{}{}
+		}
+	}
+	root := w.Filesystem.Root()
+	return filepath.Walk(root, func(p string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if info.IsDir() {
+			if info.Name() == git.GitDirName || strings.HasPrefix(info.Name(), snapshotPrefix) ||
+				matcher.Match(strings.Split(name, "/"), true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || !isSourceFile(name) {
+			return nil
+		}
+		if _, ok := tracked[name]; !ok && matcher.Match(strings.Split(name, "/"), false) {
+			return nil
+		}
+		contents, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return writeSourceFile(dir, name, contents)
+	})
+}
@@ -0,0 +1,385 @@
+package graph
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+)
+
+var testSignature = &object.Signature{Name: "calldiff", Email: "calldiff@example.com"}
+
+// testRepo 在临时目录中构造的测试仓库
+type testRepo struct {
+	t    *testing.T
+	dir  string
+	repo *git.Repository
+	now  time.Time
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	dir, err := ioutil.TempDir("", "calldiff-git-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testRepo{t: t, dir: dir, repo: r, now: time.Unix(1600000000, 0)}
+}
+
+// commit 写入文件并提交，parents 非空时构造合并提交
+func (tr *testRepo) commit(msg string, files map[string]string, parents ...plumbing.Hash) plumbing.Hash {
+	w, err := tr.repo.Worktree()
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(tr.dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			tr.t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			tr.t.Fatal(err)
+		}
+		if _, err := w.Add(name); err != nil {
+			tr.t.Fatal(err)
+		}
+	}
+	tr.now = tr.now.Add(time.Minute)
+	sig := *testSignature
+	sig.When = tr.now
+	hash, err := w.Commit(msg, &git.CommitOptions{Author: &sig, Parents: parents})
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	return hash
+}
+
+func (tr *testRepo) checkout(branch string, create bool) {
+	w, err := tr.repo.Worktree()
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	err = w.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branch), Create: create})
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+}
+
+func TestResolveCommit(t *testing.T) {
+	tr := newTestRepo(t)
+	c1 := tr.commit("c1", map[string]string{"a.go": "package a\n"})
+	c2 := tr.commit("c2", map[string]string{"a.go": "package a\n\nfunc A() {}\n"})
+	if _, err := tr.repo.CreateTag("v1.0.0", c2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.repo.CreateTag("v1.1.0", c2, &git.CreateTagOptions{Tagger: testSignature, Message: "v1.1.0"}); err != nil {
+		t.Fatal(err)
+	}
+	tr.checkout("feature", true)
+	f1 := tr.commit("f1", map[string]string{"b.go": "package a\n\nfunc B() {}\n"})
+	tr.checkout("master", false)
+	// 主干上的提交时间晚于 feature 分支，按提交时间排序会把 HEAD^ 错算成 f1
+	c3 := tr.commit("c3", map[string]string{"c.go": "package a\n\nfunc C() {}\n"})
+	merge := tr.commit("merge", map[string]string{"b.go": "package a\n\nfunc B() {}\n"}, c3, f1)
+
+	cases := map[string]plumbing.Hash{
+		"HEAD":                merge,
+		"HEAD^":               c3,
+		"HEAD^2":              f1,
+		"HEAD~2":              c2,
+		"master~3":            c1,
+		"feature":             f1,
+		"v1.0.0":              c2,
+		"v1.1.0":              c2,
+		"v1.1.0^{commit}":     c2,
+		c1.String():           c1,
+		c1.String()[:10]:      c1,
+		c2.String()[:7] + "^": c1,
+	}
+	for rev, want := range cases {
+		commit, err := resolveCommit(tr.repo, rev)
+		if err != nil {
+			t.Errorf("resolveCommit(%q): %v", rev, err)
+			continue
+		}
+		if commit.Hash != want {
+			t.Errorf("resolveCommit(%q) = %s, want %s", rev, commit.Hash, want)
+		}
+	}
+
+	for _, rev := range []string{"no-such-branch", "v9.9.9", "HEAD~10"} {
+		if _, err := resolveCommit(tr.repo, rev); err == nil {
+			t.Errorf("resolveCommit(%q) should fail", rev)
+		}
+	}
+}
+
+func TestResolveCommitAmbiguous(t *testing.T) {
+	tr := newTestRepo(t)
+	seen := make(map[string]plumbing.Hash)
+	for i := 0; ; i++ {
+		hash := tr.commit("c", map[string]string{"a.go": "package a\n\n// " + strings.Repeat("x", i) + "\n"})
+		prefix := hash.String()[:4]
+		if _, ok := seen[prefix]; ok {
+			_, err := resolveCommit(tr.repo, prefix)
+			if err == nil || !strings.Contains(err.Error(), "ambiguous") {
+				t.Fatalf("resolveCommit(%q) = %v, want ambiguous error", prefix, err)
+			}
+
+			// 与 git 一致，同名的分支优先于短 hash
+			branch := plumbing.NewHashReference(plumbing.NewBranchReferenceName(prefix), hash)
+			if err := tr.repo.Storer.SetReference(branch); err != nil {
+				t.Fatal(err)
+			}
+			parent, err := tr.repo.CommitObject(hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for rev, want := range map[string]plumbing.Hash{prefix: hash, prefix + "^": parent.ParentHashes[0]} {
+				commit, err := resolveCommit(tr.repo, rev)
+				if err != nil || commit.Hash != want {
+					t.Errorf("resolveCommit(%q) = %v, %v, want branch %s", rev, commit, err, want)
+				}
+			}
+			return
+		}
+		seen[prefix] = hash
+	}
+}
+
+func TestWorktreeAndIndexSnapshot(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.commit("c1", map[string]string{
+		".gitignore": "ignored/\n*.gen.go\n",
+		"go.mod":     "module example.com/a\n",
+		"a.go":       "package a\n",
+		"old.gen.go": "package a\n",
+	})
+	w, err := tr.repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) {
+		path := filepath.Join(tr.dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("b.go", "package a\n\nfunc B() {}\n")
+	if _, err := w.Add("b.go"); err != nil {
+		t.Fatal(err)
+	}
+	write("a.go", "package a\n\nfunc A() {}\n")
+	write("ignored/c.go", "package ignored\n")
+	write("new.gen.go", "package a\n")
+	write(snapshotPrefix+"123/d.go", "package a\n")
+
+	expect := map[string]map[string]string{
+		Index: {
+			"go.mod":     "module example.com/a\n",
+			"a.go":       "package a\n",
+			"b.go":       "package a\n\nfunc B() {}\n",
+			"old.gen.go": "package a\n",
+		},
+		Worktree: {
+			"go.mod":     "module example.com/a\n",
+			"a.go":       "package a\n\nfunc A() {}\n",
+			"b.go":       "package a\n\nfunc B() {}\n",
+			"old.gen.go": "package a\n",
+		},
+	}
+	for rev, files := range expect {
+		snap, err := getSnapshot(tr.repo, rev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir, err := ioutil.TempDir("", "calldiff-snapshot-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		if err := snap(dir); err != nil {
+			t.Fatalf("%s: %v", rev, err)
+		}
+		got := make(map[string]string)
+		_ = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				rel, _ := filepath.Rel(dir, p)
+				contents, _ := ioutil.ReadFile(p)
+				got[filepath.ToSlash(rel)] = string(contents)
+			}
+			return err
+		})
+		if len(got) != len(files) {
+			t.Errorf("%s: got files %v, want %v", rev, got, files)
+		}
+		for name, content := range files {
+			if got[name] != content {
+				t.Errorf("%s: %s = %q, want %q", rev, name, got[name], content)
+			}
+		}
+	}
+}
+
+func TestMergeBase(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.commit("c1", map[string]string{"a.go": "package a\n"})
+	c2 := tr.commit("c2", map[string]string{"a.go": "package a\n\nfunc A() {}\n"})
+	tr.checkout("feature", true)
+	tr.commit("f1", map[string]string{"b.go": "package a\n\nfunc B() {}\n"})
+	tr.checkout("master", false)
+	tr.commit("c3", map[string]string{"c.go": "package a\n\nfunc C() {}\n"})
+
+	cases := [][2]string{
+		{"master", "feature"},
+		{"feature", "master"},
+		// 工作区以 HEAD（即 master）参与计算
+		{"feature", Worktree},
+	}
+	for _, c := range cases {
+		base, err := mergeBase(tr.repo, c[0], c[1], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if base.Hash != c2 {
+			t.Errorf("mergeBase(%s, %s) = %s, want %s", c[0], c[1], base.Hash, c2)
+		}
+	}
+}
+
+func TestRangeCommits(t *testing.T) {
+	tr := newTestRepo(t)
+	c1 := tr.commit("c1", map[string]string{"a.go": "package a\n"})
+	if _, err := tr.repo.CreateTag("v1.0.0", c1, nil); err != nil {
+		t.Fatal(err)
+	}
+	c2 := tr.commit("c2", map[string]string{"a.go": "package a\n\nfunc A() {}\n"})
+	tr.checkout("feature", true)
+	f1 := tr.commit("f1", map[string]string{"b.go": "package a\n\nfunc B() {}\n"})
+	tr.checkout("master", false)
+	c3 := tr.commit("c3", map[string]string{"c.go": "package a\n\nfunc C() {}\n"})
+	merge := tr.commit("merge", map[string]string{"b.go": "package a\n\nfunc B() {}\n"}, c3, f1)
+
+	cases := []struct {
+		firstParent bool
+		want        []plumbing.Hash
+	}{
+		{true, []plumbing.Hash{c2, c3, merge}},
+		{false, []plumbing.Hash{c2, f1, c3, merge}},
+	}
+	for _, c := range cases {
+		commits, err := rangeCommits(tr.repo, "v1.0.0..master", c.firstParent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []plumbing.Hash
+		for _, commit := range commits {
+			got = append(got, commit.Hash)
+		}
+		if len(got) != len(c.want) {
+			t.Fatalf("firstParent=%v: got %v, want %v", c.firstParent, got, c.want)
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("firstParent=%v: got %v, want %v", c.firstParent, got, c.want)
+				break
+			}
+		}
+	}
+
+	for _, spec := range []string{"master", "..master", "v1.0.0...master"} {
+		if _, err := rangeCommits(tr.repo, spec, true); err == nil {
+			t.Errorf("rangeCommits(%q) should fail", spec)
+		}
+	}
+}
+
+func TestOpenRepositoryClone(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.commit("c1", map[string]string{"go.mod": "module example.com/a\n", "a.go": "package a\n"})
+	tr.commit("c2", map[string]string{"a.go": "package a\n\nfunc A() {}\n"})
+
+	// 以本地的 bare 仓库作为远端
+	remote, err := ioutil.TempDir("", "calldiff-remote-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(remote)
+	if _, err := git.PlainClone(remote, true, &git.CloneOptions{URL: tr.dir}); err != nil {
+		t.Fatal(err)
+	}
+	parent, err := ioutil.TempDir("", "calldiff-clone-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+	dir := filepath.Join(parent, "repo")
+
+	var log bytes.Buffer
+	r, err := OpenRepository(context.Background(), remote, dir, &log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.go")); err != nil {
+		t.Fatalf("repository was not cloned: %v", err)
+	}
+	if !strings.HasPrefix(log.String(), "git clone "+remote) {
+		t.Errorf("clone was not logged: %q", log.String())
+	}
+	// 目录已存在时直接打开，不会再次 clone
+	log.Reset()
+	if _, err := OpenRepository(context.Background(), remote, dir, &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Len() != 0 {
+		t.Errorf("unexpected log when opening an existing repository: %q", log.String())
+	}
+
+	// 两侧并发地在同一个仓库上输出快照
+	sides := []*common.GraphOptions{{Commit: "HEAD^"}, {Commit: "HEAD"}}
+	errs := make([]error, len(sides))
+	var wg sync.WaitGroup
+	for i, side := range sides {
+		wg.Add(1)
+		go func(i int, side *common.GraphOptions) {
+			defer wg.Done()
+			errs[i] = r.extract(side)
+		}(i, side)
+	}
+	wg.Wait()
+	want := []string{"package a\n", "package a\n\nfunc A() {}\n"}
+	for i, side := range sides {
+		if errs[i] != nil {
+			t.Fatalf("%s: %v", side.Commit, errs[i])
+		}
+		defer os.RemoveAll(side.TempPath)
+		if filepath.Dir(side.TempPath) != dir {
+			t.Errorf("%s: snapshot %s is not inside %s", side.Commit, side.TempPath, dir)
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(side.TempPath, "a.go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(contents) != want[i] {
+			t.Errorf("%s: a.go = %q, want %q", side.Commit, contents, want[i])
+		}
+	}
+}
@@ -1,17 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"flag"
+	"fmt"
 	"go/build"
+	"io"
+	"io/ioutil"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
-	"sync"
+	"strings"
 
 	"golang.org/x/tools/go/buildutil"
 
 	"github.com/bytecamp2021-calldiff/calldiff/analyze"
+	"github.com/bytecamp2021-calldiff/calldiff/cache"
+	"github.com/bytecamp2021-calldiff/calldiff/calldiff"
 	"github.com/bytecamp2021-calldiff/calldiff/common"
 	"github.com/bytecamp2021-calldiff/calldiff/graph"
+	"github.com/bytecamp2021-calldiff/calldiff/view"
 )
 
 func init() {
@@ -31,26 +41,147 @@ func init() {
 }
 
 func main() {
-	var diffOptions common.DiffOptions
-	var source, target common.GraphOptions
-	flag.StringVar(&diffOptions.URL, "url", "", `Git repository address`)
-	flag.StringVar(&diffOptions.Dir, "dir", ".", `Repository path`)
-	flag.StringVar(&source.Commit, "old", "HEAD^", `Old commit ID`)
-	flag.StringVar(&target.Commit, "new", "HEAD", `New commit ID`)
-	flag.BoolVar(&diffOptions.Test, "test", false, `Loads test code (*_test.go) for imported packages`)
-	flag.BoolVar(&diffOptions.PrintPrivate, "private", false, `If output private function`)
-	flag.BoolVar(&diffOptions.PrintUnchanged, "unchanged", false, `If output unchanged function`)
-	flag.StringVar(&diffOptions.Output, "output", "json,graphviz", `Supported output types are json and graphviz`)
-	flag.StringVar(&diffOptions.Pkg, "pkg", "main", `Analyse which packages`)
+	if len(os.Args) > 1 && os.Args[1] == "cache" {
+		cacheCommand(os.Args[2:])
+		return
+	}
+
+	var opts calldiff.Options
+	flag.StringVar(&opts.URL, "url", "", `Git repository address`)
+	flag.StringVar(&opts.Dir, "dir", ".", `Repository path`)
+	flag.StringVar(&opts.Old, "old", "HEAD^", `Old revision (commit ID, branch, tag, HEAD~n, ...)`)
+	flag.StringVar(&opts.New, "new", "HEAD", `New revision (commit ID, branch, tag, HEAD~n, ...), WORKTREE or INDEX`)
+	flag.StringVar(&opts.BaseMode, "base-mode", graph.BaseModeDirect, `How to pick the old side: direct or merge-base (merge base of old and new, like a pull request diff)`)
+	flag.StringVar(&opts.Range, "range", "", `Commit range such as v1.4.0..v1.5.0, reports the difference introduced by every commit in it`)
+	flag.BoolVar(&opts.FirstParent, "first-parent", true, `Only follow the first parent of merge commits when walking --range`)
+	flag.BoolVar(&opts.Test, "test", false, `Loads test code (*_test.go) for imported packages`)
+	flag.BoolVar(&opts.PrintPrivate, "private", false, `If output private function`)
+	flag.BoolVar(&opts.PrintUnchanged, "unchanged", false, `If output unchanged function`)
+	flag.StringVar(&opts.Algo, "algo", graph.AlgoRTA, `Call graph algorithm: static, cha, rta or vta (pointer is no longer available, use vta instead)`)
+	flag.StringVar(&opts.CacheDir, "cache-dir", "", `Directory to cache call graphs of commits in, caching is disabled if empty`)
+	flag.StringVar(&opts.Output, "output", "json,graphviz", `Comma-separated output types: json, graphviz, mermaid, plantuml, html and markdown`)
+	flag.StringVar(&opts.HashMode, "hash", analyze.HashSSA, `Function fingerprint used to detect changes: ssa, normalized, ast or source`)
+	flag.BoolVar(&opts.SeparateClosures, "separate-closures", false, `Report closures as separate functions instead of attributing them to their enclosing function`)
+	flag.BoolVar(&opts.ReportInstantiations, "instantiations", false, `Report which instantiations of generic functions appeared or disappeared`)
+	flag.StringVar(&opts.PathRoots, "path-roots", view.RootMain, `Comma-separated entry points to report call paths from: main, exported, handlers and tests`)
+	flag.IntVar(&opts.PathCount, "paths", 1, `Number of shortest call paths reported from the entry points to each changed function, 0 disables call paths`)
+	flag.BoolVar(&opts.HighlightPaths, "highlight-paths", false, `Highlight the reported call paths in the SVG`)
+	flag.StringVar(&opts.Renderer, "renderer", view.RendererAuto, `How to render difference.svg: auto (dot if it is on PATH, builtin otherwise), dot or builtin; builtin produces SVG only, no PNG`)
+	flag.IntVar(&opts.MarkdownBudget, "markdown-budget", 65000, `Maximum number of characters in difference.md, lists and the call graph are truncated to fit, 0 means unlimited`)
+	flag.IntVar(&opts.MaxDistance, "max-distance", 0, `Only report functions affected within this many calls of a changed function, 0 means unlimited`)
+	flag.StringVar(&opts.Pkg, "pkg", "main", `Analyse which packages: comma-separated package names or import path patterns (./internal/..., github.com/org/repo/api/...), prefix a pattern with - to exclude it`)
 	flag.Parse()
+	opts.Log = consoleLog{}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	_ = os.Mkdir("./output", os.ModePerm)
+	if opts.Range != "" {
+		writers, save := bufferOutputs(map[string]string{"json": "range.json", "markdown": "range.md"})
+		opts.Writers = writers
+		_, err := calldiff.RunRange(ctx, opts)
+		common.CheckIfError(err)
+		common.CheckIfError(save())
+		return
+	}
+
+	files := make(map[string]string)
+	for _, output := range strings.Split(opts.Output, ",") {
+		switch output {
+		case "json":
+			files["json"] = "difference.json"
+		case "graphviz":
+			files["graphviz"] = "difference.gv"
+			files["svg"] = "difference.svg"
+		case "mermaid":
+			files["mermaid"] = "difference.mmd"
+		case "plantuml":
+			files["plantuml"] = "difference.puml"
+		case "html":
+			files["html"] = "difference.html"
+		case "markdown":
+			files["markdown"] = "difference.md"
+		default:
+			common.CheckIfError(common.WrapError(common.ExitOutputError, fmt.Errorf("unsupported output type %s", output)))
+		}
+	}
+	writers, save := bufferOutputs(files)
+	opts.Writers = writers
+	_, err := calldiff.Run(ctx, opts)
+	common.CheckIfError(err)
+	common.CheckIfError(save())
+}
+
+// consoleLog 将库输出的提示信息以彩色打印到标准输出，警告与普通信息使用不同的颜色
+type consoleLog struct{}
+
+func (consoleLog) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		if strings.HasPrefix(line, common.WarningPrefix) {
+			common.Warning("%s", strings.TrimPrefix(line, common.WarningPrefix))
+		} else {
+			common.Info("%s", line)
+		}
+	}
+	return len(p), nil
+}
 
-	// Get commits' callgraph
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go graph.GetCallGraph(&diffOptions, &source, &wg)
-	go graph.GetCallGraph(&diffOptions, &target, &wg)
-	wg.Wait()
+// bufferOutputs 为各输出格式准备内存中的 Writer，分析成功后调用返回的 save 才将结果写入 ./output，
+// 失败的运行不会覆盖上一次的结果
+func bufferOutputs(files map[string]string) (map[string]io.Writer, func() error) {
+	writers := make(map[string]io.Writer)
+	buffers := make(map[string]*bytes.Buffer)
+	for output := range files {
+		buffers[output] = &bytes.Buffer{}
+		writers[output] = buffers[output]
+	}
+	save := func() error {
+		for output, name := range files {
+			if err := writeFile(filepath.Join("./output", name), buffers[output].Bytes()); err != nil {
+				return common.WrapError(common.ExitOutputError, err)
+			}
+		}
+		return nil
+	}
+	return writers, save
+}
 
-	diffGraph := analyze.GetDiff(source.CallGraph, target.CallGraph)
-	diffGraph.OutputDiffGraph(&diffOptions)
+// writeFile 先写入同目录下的临时文件再重命名为 path，写入中途失败时 path 保持原样
+func writeFile(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	// 与 os.Create 创建的文件权限保持一致
+	if err := f.Chmod(0644); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// cacheCommand 处理 calldiff cache 子命令
+func cacheCommand(args []string) {
+	if len(args) == 0 || args[0] != "prune" {
+		common.CheckIfError(fmt.Errorf("usage: %s cache prune --cache-dir=<dir> [--max-age=<duration>]", os.Args[0]))
+	}
+	fs := flag.NewFlagSet("cache prune", flag.ExitOnError)
+	dir := fs.String("cache-dir", "", `Cache directory to prune`)
+	maxAge := fs.Duration("max-age", 0, `Remove entries not used for longer than this, 0 removes all entries`)
+	_ = fs.Parse(args[1:])
+	if *dir == "" {
+		common.CheckIfError(fmt.Errorf("--cache-dir is required"))
+	}
+	removed, err := (&cache.Cache{Dir: *dir}).Prune(*maxAge)
+	common.CheckIfError(err)
+	common.Info("removed %d cache entries from %s", removed, *dir)
 }
@@ -1,13 +1,14 @@
 package view
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
-	"os"
 	"os/exec"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"unicode"
 
@@ -24,17 +25,79 @@ const (
 	REMOVED          // 删除
 	CHANGED          // 变化
 	AFFECTED         // 传播中受到了影响
+	MOVED            // 移动到了其他包（可能同时重命名）
+	RENAMED          // 在同一个包中重命名
 )
 
+// 节点本身改变的原因
+const (
+	BodyChanged          = "body changed"           // 函数的代码改变
+	CallStructureChanged = "call structure changed" // 代码不变，但调用的函数集合改变
+	ValueChanged         = "value changed"          // 全局变量的初始值或常量的值改变
+	TypeChanged          = "type changed"           // 类型的字段、标签或方法集改变
+)
+
+// 全局变量与常量节点的种类，函数节点的种类为空
+const (
+	GlobalVar   = "var"
+	GlobalConst = "const"
+	NamedType   = "type"
+)
+
+// 函数对全局变量、常量与类型的访问方式，调用边的访问方式为空
+const (
+	Reads  = "reads"
+	Writes = "writes"
+	Uses   = "uses" // 函数的签名或函数体、类型的字段或底层类型中用到了该类型
+)
+
+// TypeChange 类型在两个版本中的差异，字段与方法以名称表示
+type TypeChange struct {
+	Kind              string   `json:"kind"` // struct、interface 或 other
+	FieldsAdded       []string `json:"fields_added"`
+	FieldsRemoved     []string `json:"fields_removed"`
+	FieldsChanged     []string `json:"fields_changed"` // 类型或是否嵌入改变
+	TagsChanged       []string `json:"tags_changed"`
+	MethodsAdded      []string `json:"methods_added"`
+	MethodsRemoved    []string `json:"methods_removed"`
+	MethodsChanged    []string `json:"methods_changed"` // 签名改变
+	UnderlyingChanged bool     `json:"underlying_changed"`
+}
+
+// SourceDiff 函数在两个版本中的位置与源代码差异，文件为相对于仓库根目录的路径
+type SourceDiff struct {
+	OldFile      string `json:"old_file"`
+	OldStartLine int    `json:"old_start_line"`
+	OldEndLine   int    `json:"old_end_line"`
+	NewFile      string `json:"new_file"`
+	NewStartLine int    `json:"new_start_line"`
+	NewEndLine   int    `json:"new_end_line"`
+	Diff         string `json:"diff"` // 函数源代码的 unified diff
+}
+
 type DiffEdge struct {
 	Node       *DiffNode //连接的点
 	Difference DiffType
+	Access     string //指向全局变量或常量时为 Reads 或 Writes，只有读取会受到其改变的影响；指向类型时为 Uses
 }
 
 type DiffNode struct {
-	Name       string               //函数名称
-	Difference DiffType             //0本身代码无变化，1新增，2删除，3本身的代码改变
-	CallEdge   map[string]*DiffEdge //调用的函数，map[调用的函数名称]
+	Name             string               //函数名称
+	Kind             string               //节点的种类：函数为空，全局变量为 GlobalVar，常量为 GlobalConst，类型为 NamedType
+	Difference       DiffType             //0本身代码无变化，1新增，2删除，3本身的代码改变
+	Reason           string               //Difference 为 CHANGED 时改变的原因，BodyChanged 或 CallStructureChanged
+	Changes          []string             //Difference 为 CHANGED 时具体改变的内容，如 signature_changed、loops_changed
+	Source           *SourceDiff          //Difference 为 CHANGED 时函数的源代码差异
+	MovedFrom        string               //Difference 为 MOVED 或 RENAMED 时旧版本中的函数名称
+	Confidence       float64              //Difference 为 MOVED 或 RENAMED 时配对的置信度，取值范围为 [0, 1]
+	InstancesAdded   []string             //泛型函数新出现的实例的类型实参
+	InstancesRemoved []string             //泛型函数不再出现的实例的类型实参
+	TypeChange       *TypeChange          //Kind 为 NamedType 时类型的差异
+	EntryKinds       []string             //由签名判断的入口函数种类，RootHandlers 或 RootTests
+	Paths            [][]string           //FindPaths 找到的从入口函数到该函数的调用链，以入口函数开头
+	CallEdge         map[string]*DiffEdge //调用的函数，map[调用的函数名称]
+	Distance         int                  //沿调用边到最近的代码改变的函数的跳数，本身改变为0，未受影响为-1
+	RootCauses       map[string]int       //影响到该节点的所有代码改变的函数，map[函数名称]跳数
 }
 
 func (n *DiffNode) GetPkgName() string {
@@ -67,12 +130,23 @@ func (n *DiffNode) IsPrivate() bool {
 	return !unicode.IsUpper([]rune(splits[2])[0]) && splits[2] != "main" && !match
 }
 
+// InPackages 判断节点所在的包是否被 filter 选中
+func (n *DiffNode) InPackages(filter *common.PkgFilter) bool {
+	return filter.Match(n.GetPath(), n.GetPkgName())
+}
+
 func (n *DiffNode) GetPrettyName() string {
 	return fmt.Sprintf("%s.%s", n.GetPkgName(), n.GetFuncName())
 }
 
 type DiffGraph struct {
-	Nodes map[string]*DiffNode
+	Nodes  map[string]*DiffNode
+	Module string // 新版本的模块路径，用于解析 --pkg 中相对于模块根目录的模式
+}
+
+// PkgFilter 返回 o.Pkg 对应的包过滤器
+func (g *DiffGraph) PkgFilter(o *common.DiffOptions) *common.PkgFilter {
+	return common.ParsePkgFilter(o.Pkg).WithModule(g.Module)
 }
 
 // NewDiffGraphHelper 方便申请节点
@@ -85,6 +159,8 @@ func NewDiffGraphHelper() *DiffGraph {
 func NewDiffNodeHelper() *DiffNode {
 	var ans = new(DiffNode)
 	ans.CallEdge = make(map[string]*DiffEdge)
+	ans.Distance = -1
+	ans.RootCauses = make(map[string]int)
 	return ans
 }
 
@@ -104,25 +180,61 @@ func (g *DiffGraph) DebugDiffGraph() {
 	}
 }
 
-func (g *DiffGraph) OutputDiffGraph(o *common.DiffOptions) {
-	outputs := strings.Split(o.Output, ",")
-	_ = os.Mkdir("./output", os.ModePerm)
-	for _, output := range outputs {
+// OutputTypes 支持的输出格式，按输出顺序排列
+var OutputTypes = []string{"json", "graphviz", "svg", "mermaid", "plantuml", "html", "markdown"}
+
+// OutputDiffGraph 将差异按格式写入 writers 中对应的 Writer，
+// 支持的格式见 OutputTypes，其中 graphviz 为 dot 源码，svg 由 dot 命令或内置的布局渲染，
+// mermaid 与 plantuml 为与 graphviz 显示相同节点与边的 Mermaid 流程图与 PlantUML 图，html 为附带源代码差异的报告，
+// markdown 为适合贴在合并请求评论中的摘要。
+// 某种输出失败时仍会尝试其余的输出，返回的错误带有 common.ExitOutputError 退出码
+func (g *DiffGraph) OutputDiffGraph(o *common.DiffOptions, writers map[string]io.Writer) error {
+	var errs []string
+	for output := range writers {
+		if !isOutputType(output) {
+			errs = append(errs, fmt.Sprintf("%s: unsupported output type", output))
+		}
+	}
+	for _, output := range OutputTypes {
+		w, ok := writers[output]
+		if !ok {
+			continue
+		}
+		var err error
 		switch output {
 		case "json":
-			err := OutputJSON(g, o.PrintPrivate, o.PrintUnchanged, o.Pkg)
-			if err != nil {
-				fmt.Println(err)
-			}
+			err = OutputJSON(w, g, o)
 		case "graphviz":
-			err := g.Visualization(o.PrintPrivate, o.PrintUnchanged, o.Pkg)
-			if err != nil {
-				fmt.Println(err)
-			}
-		default:
-			fmt.Println("Unsupported output type", output)
+			err = g.Visualization(w, o)
+		case "svg":
+			err = g.RenderSVG(w, o)
+		case "mermaid":
+			err = g.MermaidVisualization(w, o)
+		case "plantuml":
+			err = g.PlantUMLVisualization(w, o)
+		case "html":
+			err = OutputHTML(w, g, o)
+		case "markdown":
+			err = OutputMarkdown(w, g, o)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", output, err))
 		}
 	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return common.WrapError(common.ExitOutputError, errors.New(strings.Join(errs, "; ")))
+	}
+	return nil
+}
+
+func isOutputType(output string) bool {
+	for _, t := range OutputTypes {
+		if t == output {
+			return true
+		}
+	}
+	return false
 }
 
 func dfsDiffNode(n *DiffNode, doPrintPrivate bool, doPrintUnchanged bool, vis *map[*DiffNode]struct{}) {
@@ -141,43 +253,62 @@ func dfsDiffNode(n *DiffNode, doPrintPrivate bool, doPrintUnchanged bool, vis *m
 	}
 }
 
-func (g *DiffGraph) Visualization(doPrintPrivate bool, doPrintUnchanged bool, pkg string) error {
-	graphAst, _ := gographviz.ParseString(`digraph G {}`)
-	graph := gographviz.NewGraph()
-	if err := gographviz.Analyse(graphAst, graph); err != nil {
-		return err
-	}
-	err := graph.AddAttr("G", "rankdir", `"LR"`)
-	if err != nil {
-		return err
-	}
-	// 定义属性
-	lineColorMap := map[DiffType]string{
+// 各种差异对应的边框与边的颜色、边的样式、节点的填充颜色，取值为 Graphviz 属性的写法
+var (
+	lineColorMap = map[DiffType]string{
 		UNCHANGED: "\"#000000\"",
 		INSERTED:  "\"#82B366\"",
 		REMOVED:   "\"#B85450\"",
 		CHANGED:   "\"#D79B00\"",
 		AFFECTED:  "\"#D7B953\"",
+		MOVED:     "\"#9673A6\"",
+		RENAMED:   "\"#9673A6\"",
 	}
-	lineStyleMap := map[DiffType]string{
+	lineStyleMap = map[DiffType]string{
 		UNCHANGED: `""`,
 		INSERTED:  `""`,
 		REMOVED:   `dashed`,
 		CHANGED:   `""`,
 		AFFECTED:  `""`,
 	}
-	fillColorMap := map[DiffType]string{
+	fillColorMap = map[DiffType]string{
 		UNCHANGED: "\"#DAE8FC\"",
 		INSERTED:  "\"#D5E8D4\"",
 		REMOVED:   "\"#F8CECC\"",
 		CHANGED:   "\"#FFE6CC\"",
 		AFFECTED:  "\"#FFF2CD\"",
+		MOVED:     "\"#E1D5E7\"",
+		RENAMED:   "\"#E1D5E7\"",
+	}
+	// 移动或重命名的函数使用双线边框，并在标签中注明旧名称
+	nodeStyleMap = map[DiffType]string{
+		MOVED:   `"filled,bold"`,
+		RENAMED: `"filled,bold"`,
 	}
+)
+
+// visibleGraph 按输出选项确定的要显示的节点与边，Graphviz 与内置的渲染共用
+type visibleGraph struct {
+	nodes       []*DiffNode // 按名称排列
+	edges       []visibleEdge
+	highlighted map[*DiffNode]bool // 调用链上的节点
+}
+
+type visibleEdge struct {
+	from        *DiffNode
+	edge        *DiffEdge
+	highlighted bool // 在调用链上
+}
+
+// visibleGraph 从 --pkg 选中的节点出发遍历确定要显示的节点与边，
+// 指定 --highlight-paths 时调用链上的节点与边即使不满足输出条件也会显示
+func (g *DiffGraph) visibleGraph(o *common.DiffOptions) *visibleGraph {
+	doPrintPrivate, doPrintUnchanged, filter := o.PrintPrivate, o.PrintUnchanged, g.PkgFilter(o)
 	// 遍历确定哪些节点可达
 	vis := make(map[*DiffNode]struct{})
 	for _, node := range g.Nodes {
 		if _, ok := vis[node]; !ok {
-			if node.GetPkgName() != pkg {
+			if !node.InPackages(filter) {
 				continue
 			}
 			if !doPrintPrivate && node.IsPrivate() {
@@ -189,51 +320,202 @@ func (g *DiffGraph) Visualization(doPrintPrivate bool, doPrintUnchanged bool, pk
 			dfsDiffNode(node, doPrintPrivate, doPrintUnchanged, &vis)
 		}
 	}
-	// 将所有节点加入到图中
-	for _, node := range g.Nodes {
-		if _, ok := vis[node]; !ok {
-			continue
+	vg := &visibleGraph{highlighted: make(map[*DiffNode]bool)}
+	onPath := make(map[*DiffNode]map[*DiffNode]bool)
+	if o.HighlightPaths {
+		for _, node := range g.Nodes {
+			for _, path := range node.Paths {
+				for i, name := range path {
+					vg.highlighted[g.Nodes[name]] = true
+					vis[g.Nodes[name]] = struct{}{}
+					if i == 0 {
+						continue
+					}
+					caller := g.Nodes[path[i-1]]
+					if onPath[caller] == nil {
+						onPath[caller] = make(map[*DiffNode]bool)
+					}
+					onPath[caller][g.Nodes[name]] = true
+				}
+			}
+		}
+	}
+	for node := range vis {
+		vg.nodes = append(vg.nodes, node)
+	}
+	sort.Slice(vg.nodes, func(i, j int) bool { return vg.nodes[i].Name < vg.nodes[j].Name })
+	for _, node := range vg.nodes {
+		for _, edge := range node.CallEdge {
+			if _, ok := vis[edge.Node]; !ok {
+				continue
+			}
+			if !doPrintUnchanged && edge.Difference == UNCHANGED && !onPath[node][edge.Node] {
+				continue
+			}
+			vg.edges = append(vg.edges, visibleEdge{from: node, edge: edge, highlighted: onPath[node][edge.Node]})
+		}
+	}
+	sort.Slice(vg.edges, func(i, j int) bool {
+		a, b := vg.edges[i], vg.edges[j]
+		if a.from != b.from {
+			return a.from.Name < b.from.Name
 		}
+		return a.edge.Node.Name < b.edge.Node.Name
+	})
+	return vg
+}
+
+// packages 返回要显示的节点所在的包的路径，按路径排列，以及各个包中的节点
+func (vg *visibleGraph) packages() ([]string, map[string][]*DiffNode) {
+	var paths []string
+	clusters := make(map[string][]*DiffNode)
+	for _, n := range vg.nodes {
+		if _, ok := clusters[n.GetPath()]; !ok {
+			paths = append(paths, n.GetPath())
+		}
+		clusters[n.GetPath()] = append(clusters[n.GetPath()], n)
+	}
+	sort.Strings(paths)
+	return paths, clusters
+}
+
+// sortedDifferences 返回所有的差异种类，按取值排列
+func sortedDifferences() []DiffType {
+	var differences []DiffType
+	for difference := range differenceName {
+		differences = append(differences, difference)
+	}
+	sort.Slice(differences, func(i, j int) bool { return differences[i] < differences[j] })
+	return differences
+}
+
+// label 返回节点显示的标签：全局变量、常量与类型注明种类，移动或重命名的函数注明旧名称
+func (n *DiffNode) label() string {
+	if _, ok := nodeStyleMap[n.Difference]; ok {
+		return n.GetFuncName() + "\n(" + n.movedLabel() + ")"
+	}
+	if n.Kind != "" {
+		return n.Kind + " " + n.GetFuncName()
+	}
+	return n.GetFuncName()
+}
+
+// Visualization 将差异以 Graphviz dot 源码的形式写入 w
+func (g *DiffGraph) Visualization(w io.Writer, o *common.DiffOptions) error {
+	graphAst, _ := gographviz.ParseString(`digraph G {}`)
+	graph := gographviz.NewGraph()
+	if err := gographviz.Analyse(graphAst, graph); err != nil {
+		return err
+	}
+	err := graph.AddAttr("G", "rankdir", `"LR"`)
+	if err != nil {
+		return err
+	}
+	vg := g.visibleGraph(o)
+	// 将所有节点加入到图中
+	for _, node := range vg.nodes {
 		if !graph.IsSubGraph(node.GetPkgName()) {
 			_ = graph.AddSubGraph("G", `cluster_`+cleanPathSep(node.GetPath()), map[string]string{ // 必须以cluster开头，否则不加框
 				"label": "\"" + node.GetPkgName() + "\n(" + node.GetPath() + ")\"",
 			})
 		}
-		_ = graph.AddNode(`cluster_`+cleanPathSep(node.GetPath()), `"`+node.Name+`"`, map[string]string{
+		attrs := map[string]string{
 			"color":     lineColorMap[node.Difference],
-			"label":     `"` + node.GetFuncName() + `"`,
+			"label":     strconv.Quote(node.label()),
 			"style":     "filled",
 			"fillcolor": fillColorMap[node.Difference],
-		})
+		}
+		// 全局变量、常量与类型使用方框
+		if node.Kind != "" {
+			attrs["shape"] = "box"
+		}
+		if style, ok := nodeStyleMap[node.Difference]; ok {
+			attrs["style"] = style
+			attrs["peripheries"] = "2"
+		}
+		if tooltip := node.tooltip(g); tooltip != "" {
+			attrs["tooltip"] = strconv.Quote(tooltip)
+		}
+		if vg.highlighted[node] {
+			attrs["penwidth"] = "3"
+		}
+		_ = graph.AddNode(`cluster_`+cleanPathSep(node.GetPath()), `"`+node.Name+`"`, attrs)
 	}
 	// 添加边
-	for _, node := range g.Nodes {
-		if _, ok := vis[node]; !ok {
-			continue
+	for _, e := range vg.edges {
+		attrs := map[string]string{
+			"color": lineColorMap[e.edge.Difference],
+			"style": lineStyleMap[e.edge.Difference],
 		}
-		for _, edge := range node.CallEdge {
-			if _, ok := vis[edge.Node]; !ok {
-				continue
-			}
-			if !doPrintUnchanged && edge.Difference == UNCHANGED {
-				continue
-			}
-			_ = graph.AddEdge(`"`+node.Name+`"`, `"`+edge.Node.Name+`"`, true, map[string]string{
-				"color": lineColorMap[edge.Difference],
-				"style": lineStyleMap[edge.Difference],
-			})
+		// 对全局变量、常量与类型的访问使用点线，写入时注明
+		if e.edge.Access != "" && e.edge.Difference != REMOVED {
+			attrs["style"] = "dotted"
 		}
+		if e.edge.Access == Writes {
+			attrs["label"] = Writes
+		}
+		if e.highlighted {
+			attrs["penwidth"] = "3"
+		}
+		_ = graph.AddEdge(`"`+e.from.Name+`"`, `"`+e.edge.Node.Name+`"`, true, attrs)
 	}
 	// GenerateLegend(graph, lineColorMap, fillColorMap, lineStyleMap)
-	err = ioutil.WriteFile("./output/difference.gv", []byte(graph.String()), 0644)
+	_, err = io.WriteString(w, graph.String())
+	return err
+}
+
+// movedLabel 返回移动或重命名的函数的说明，如 "renamed from main.foo, 92%"
+func (n *DiffNode) movedLabel() string {
+	kind := "moved"
+	if n.Difference == RENAMED {
+		kind = "renamed"
+	}
+	return fmt.Sprintf("%s from %s, %.0f%%", kind, prettyName(n.MovedFrom), n.Confidence*100)
+}
+
+// prettyName 与 GetPrettyName 一致，用于已不在图中的函数
+func prettyName(name string) string {
+	return (&DiffNode{Name: name}).GetPrettyName()
+}
+
+// tooltip 返回节点在 Graphviz 中的提示：代码改变的函数列出具体改变的内容，受影响的函数列出根因
+func (n *DiffNode) tooltip(g *DiffGraph) string {
+	switch n.Difference {
+	case MOVED, RENAMED:
+		if len(n.Changes) > 0 {
+			return n.movedLabel() + ": " + strings.Join(n.Changes, ", ")
+		}
+		return n.movedLabel()
+	case CHANGED:
+		return strings.Join(n.Changes, ", ")
+	case AFFECTED:
+		causes := make([]string, 0, len(n.RootCauses))
+		for name := range n.RootCauses {
+			if cause, ok := g.Nodes[name]; ok {
+				causes = append(causes, cause.GetPrettyName())
+			}
+		}
+		sort.Strings(causes)
+		return "affected by " + strings.Join(causes, ", ")
+	}
+	return ""
+}
+
+// RenderSVG 将差异渲染为 SVG 并写入 w，按 o.Renderer 调用 dot 命令或使用内置的渲染，
+// 默认在找不到 dot 命令时使用内置的渲染
+func (g *DiffGraph) RenderSVG(w io.Writer, o *common.DiffOptions) error {
+	dot, err := useDot(o.Renderer)
 	if err != nil {
 		return err
 	}
-	err = execCommand(`dot`, "./output/difference.gv", "-Tsvg", "-o", "./output/difference.svg")
-	if err != nil {
+	if !dot {
+		return g.RenderBuiltinSVG(w, o)
+	}
+	var source bytes.Buffer
+	if err := g.Visualization(&source, o); err != nil {
 		return err
 	}
-	return nil
+	return execCommand(&source, w, `dot`, "-Tsvg")
 }
 
 func GenerateLegend(graph *gographviz.Graph, lineColorMap map[DiffType]string, fillColorMap map[DiffType]string, lineStyleMap map[DiffType]string) {
@@ -326,48 +608,93 @@ func GenerateLegend(graph *gographviz.Graph, lineColorMap map[DiffType]string, f
 	})
 }
 
-func execCommand(programName string, programArgs ...string) error {
+// execCommand 运行命令，stdin 作为标准输入，标准输出写入 stdout，失败时错误中附带标准错误的内容
+func execCommand(stdin io.Reader, stdout io.Writer, programName string, programArgs ...string) error {
+	var stderr bytes.Buffer
 	cmd := exec.Command(programName, programArgs...)
-	stdout, err := cmd.StdoutPipe()
-	stderr, _ := cmd.StderrPipe()
-	if err != nil {
-		return err
-	}
-	defer func(stdout io.ReadCloser) {
-		_ = stdout.Close()
-	}(stdout)
-	defer func(stderr io.ReadCloser) {
-		_ = stderr.Close()
-	}(stderr)
-	if err := cmd.Start(); err != nil { // 运行命令
-		return err
-	}
-	opBytes, err := ioutil.ReadAll(stdout)
-	if err != nil { // 读取输出结果
-		return err
-	}
-	if len(opBytes) >= 2 {
-		log.Println(string(opBytes))
-	}
-	if opBytes, err := ioutil.ReadAll(stderr); err != nil { // 读取输出结果
-		log.Fatal(err)
-	} else {
-		if len(opBytes) >= 2 {
-			log.Println(string(opBytes))
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil { // 运行命令
+		if stderr.Len() > 0 {
+			return fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
 		}
+		return err
 	}
 	return nil
 }
 
+// CalcAffected 沿调用边反向传播代码改变：从每个 CHANGED 节点出发，经新版本中存在的调用边（即非 REMOVED 的边）
+// 逆向可达的节点都受其影响，记录下跳数与全部根因，原本 UNCHANGED 的节点标记为 AFFECTED，指向受影响节点的边标记为 CHANGED。
+// 全局变量与常量的改变经读取边传播给读取它的函数，写入边不传播；类型的改变经使用边传播给用到它的函数与类型
 func (g *DiffGraph) CalcAffected() {
-	for _, value := range g.Nodes {
-		if value.Difference != UNCHANGED {
+	callers := make(map[*DiffNode][]*DiffNode)
+	for _, node := range g.Nodes {
+		if node.Difference == REMOVED {
+			continue
+		}
+		for _, edge := range node.CallEdge {
+			if edge.Difference != REMOVED && edge.Node.Difference != REMOVED && edge.Access != Writes {
+				callers[edge.Node] = append(callers[edge.Node], node)
+			}
+		}
+	}
+	for _, cause := range g.Nodes {
+		// 移动或重命名时代码同时改变的函数也会影响调用者
+		if cause.Difference != CHANGED && cause.Reason == "" {
 			continue
 		}
-		for _, edge := range value.CallEdge {
-			if edge.Difference == CHANGED {
-				value.Difference = AFFECTED
-				break
+		// 以 cause 为起点做 BFS
+		dist := map[*DiffNode]int{cause: 0}
+		queue := []*DiffNode{cause}
+		for len(queue) != 0 {
+			node := queue[0]
+			queue = queue[1:]
+			node.RootCauses[cause.Name] = dist[node]
+			for _, caller := range callers[node] {
+				if _, ok := dist[caller]; !ok {
+					dist[caller] = dist[node] + 1
+					queue = append(queue, caller)
+				}
+			}
+		}
+	}
+	g.FilterDistance(0)
+}
+
+// FilterDistance 只保留距离代码改变的函数不超过 maxDistance 跳的影响，maxDistance 为 0 时不做限制
+func (g *DiffGraph) FilterDistance(maxDistance int) {
+	for _, node := range g.Nodes {
+		node.Distance = -1
+		for cause, d := range node.RootCauses {
+			if maxDistance > 0 && d > maxDistance {
+				delete(node.RootCauses, cause)
+				continue
+			}
+			if node.Distance == -1 || d < node.Distance {
+				node.Distance = d
+			}
+		}
+		if node.Difference == AFFECTED && node.Distance == -1 {
+			node.Difference = UNCHANGED
+		} else if node.Difference == UNCHANGED && node.Distance > 0 {
+			node.Difference = AFFECTED
+		}
+	}
+	for _, node := range g.Nodes {
+		for _, edge := range node.CallEdge {
+			if edge.Difference != UNCHANGED && edge.Difference != CHANGED {
+				continue
+			}
+			if edge.Access == Writes {
+				edge.Difference = UNCHANGED
+				continue
+			}
+			d := edge.Node.Distance
+			if d >= 0 && (maxDistance == 0 || d < maxDistance) {
+				edge.Difference = CHANGED
+			} else {
+				edge.Difference = UNCHANGED
 			}
 		}
 	}
@@ -0,0 +1,84 @@
+package view
+
+import (
+	"reflect"
+	"testing"
+)
+
+// chainGraph 构造调用链 main -> a -> b -> c -> d，其中 d 与 c 的代码改变，另有未受影响的 e
+func chainGraph() *DiffGraph {
+	g := NewDiffGraphHelper()
+	for _, name := range []string{"main", "a", "b", "c", "d", "e"} {
+		node := NewDiffNodeHelper()
+		node.Name = "example.com/m#main#" + name + "#"
+		g.Nodes[name] = node
+	}
+	link := func(caller, callee string) {
+		g.Nodes[caller].CallEdge[g.Nodes[callee].Name] = NewDiffEdgeHelper(g.Nodes[callee])
+	}
+	link("main", "a")
+	link("a", "b")
+	link("b", "c")
+	link("c", "d")
+	link("main", "e")
+	g.Nodes["c"].Difference = CHANGED
+	g.Nodes["d"].Difference = CHANGED
+	// 以 Name 为 key，与 analyze 中构造的 DiffGraph 一致
+	nodes := make(map[string]*DiffNode)
+	for _, node := range g.Nodes {
+		nodes[node.Name] = node
+	}
+	g.Nodes = nodes
+	return g
+}
+
+func node(g *DiffGraph, name string) *DiffNode {
+	return g.Nodes["example.com/m#main#"+name+"#"]
+}
+
+func TestCalcAffected(t *testing.T) {
+	g := chainGraph()
+	g.CalcAffected()
+	cases := []struct {
+		name       string
+		difference DiffType
+		distance   int
+		causes     map[string]int
+	}{
+		{"d", CHANGED, 0, map[string]int{"d": 0}},
+		{"c", CHANGED, 0, map[string]int{"c": 0, "d": 1}},
+		{"b", AFFECTED, 1, map[string]int{"c": 1, "d": 2}},
+		{"a", AFFECTED, 2, map[string]int{"c": 2, "d": 3}},
+		{"main", AFFECTED, 3, map[string]int{"c": 3, "d": 4}},
+		{"e", UNCHANGED, -1, map[string]int{}},
+	}
+	for _, c := range cases {
+		n := node(g, c.name)
+		causes := make(map[string]int)
+		for cause, d := range n.RootCauses {
+			causes[g.Nodes[cause].GetFuncName()] = d
+		}
+		if n.Difference != c.difference || n.Distance != c.distance || !reflect.DeepEqual(causes, c.causes) {
+			t.Errorf("%s: difference %d, distance %d, causes %v; want %d, %d, %v",
+				c.name, n.Difference, n.Distance, causes, c.difference, c.distance, c.causes)
+		}
+	}
+	if edge := node(g, "main").CallEdge[node(g, "a").Name]; edge.Difference != CHANGED {
+		t.Error("edge main -> a should be marked as changed")
+	}
+	if edge := node(g, "main").CallEdge[node(g, "e").Name]; edge.Difference != UNCHANGED {
+		t.Error("edge main -> e should stay unchanged")
+	}
+
+	// 只保留两跳以内的影响
+	g.FilterDistance(2)
+	if n := node(g, "a"); n.Difference != AFFECTED || len(n.RootCauses) != 1 {
+		t.Errorf("a: difference %d, causes %v", n.Difference, n.RootCauses)
+	}
+	if n := node(g, "main"); n.Difference != UNCHANGED || n.Distance != -1 {
+		t.Errorf("main: difference %d, distance %d", n.Difference, n.Distance)
+	}
+	if edge := node(g, "main").CallEdge[node(g, "a").Name]; edge.Difference != UNCHANGED {
+		t.Error("edge main -> a should be unchanged beyond max distance")
+	}
+}
@@ -0,0 +1,147 @@
+package view
+
+import (
+	"strings"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+)
+
+// htmlGraph 嵌入 HTML 报告中的差异图，由页面中的脚本绘制。
+// 节点与边为 --private、--unchanged 都指定时要显示的全部节点与边，
+// Views 为四种开关组合下各自的布局，页面中切换开关时直接换用对应的布局，无需重新运行
+type htmlGraph struct {
+	Nodes     []htmlGraphNode `json:"nodes"`
+	Edges     []htmlGraphEdge `json:"edges"`
+	Views     []htmlGraphView `json:"views"` // 下标为 private*2+unchanged
+	Private   bool            `json:"private"`
+	Unchanged bool            `json:"unchanged"`
+}
+
+type htmlGraphNode struct {
+	Name         string         `json:"name"`
+	Label        []string       `json:"label"`
+	Kind         string         `json:"kind"`
+	Difference   string         `json:"difference"`
+	Title        string         `json:"title"`
+	Fill         string         `json:"fill"`
+	Stroke       string         `json:"stroke"`
+	Double       bool           `json:"double"` // 移动或重命名的函数使用双线边框
+	Highlighted  bool           `json:"highlighted"`
+	MovedFrom    string         `json:"moved_from"`
+	Changes      []string       `json:"changes"`
+	AddedCall    []string       `json:"added_call"`
+	DeletedCall  []string       `json:"deleted_call"`
+	AffectedCall []affectedCall `json:"affected_call"`
+	RootCauses   []string       `json:"root_causes"`
+	Source       *SourceDiff    `json:"source"`
+}
+
+type htmlGraphEdge struct {
+	From        int    `json:"from"`
+	To          int    `json:"to"`
+	Stroke      string `json:"stroke"`
+	Dash        string `json:"dash"`
+	Label       string `json:"label"`
+	Highlighted bool   `json:"highlighted"`
+}
+
+type htmlGraphView struct {
+	Width    int                `json:"width"`
+	Height   int                `json:"height"`
+	Clusters []htmlGraphCluster `json:"clusters"`
+	Nodes    []htmlNodeLayout   `json:"nodes"`
+	Edges    []htmlEdgeLayout   `json:"edges"`
+}
+
+type htmlGraphCluster struct {
+	Label  string `json:"label"`
+	Y      int    `json:"y"`
+	Height int    `json:"height"`
+}
+
+type htmlNodeLayout struct {
+	ID int `json:"id"`
+	X  int `json:"x"`
+	Y  int `json:"y"`
+	W  int `json:"w"`
+	H  int `json:"h"`
+}
+
+type htmlEdgeLayout struct {
+	ID     int    `json:"id"`
+	Path   string `json:"path"`
+	LabelX int    `json:"label_x"`
+	LabelY int    `json:"label_y"`
+}
+
+// newHTMLGraph 按内置渲染的分层布局整理出嵌入 HTML 报告的差异图
+func newHTMLGraph(g *DiffGraph, o *common.DiffOptions) *htmlGraph {
+	result := &htmlGraph{Private: o.PrintPrivate, Unchanged: o.PrintUnchanged}
+	all := *o
+	all.PrintPrivate, all.PrintUnchanged = true, true
+	vg := g.visibleGraph(&all)
+	nodeIDs := make(map[*DiffNode]int)
+	for i, n := range vg.nodes {
+		nodeIDs[n] = i
+		node := htmlGraphNode{
+			Name:        n.GetPrettyName(),
+			Label:       strings.Split(n.label(), "\n"),
+			Kind:        n.Kind,
+			Difference:  differenceName[n.Difference],
+			Title:       n.tooltip(g),
+			Fill:        svgColor(fillColorMap[n.Difference]),
+			Stroke:      svgColor(lineColorMap[n.Difference]),
+			Highlighted: vg.highlighted[n],
+			Changes:     n.Changes,
+			Source:      n.Source,
+		}
+		if n.MovedFrom != "" {
+			node.MovedFrom = prettyName(n.MovedFrom)
+		}
+		_, node.Double = nodeStyleMap[n.Difference]
+		if n.Kind == "" && (n.Difference == CHANGED || n.Difference == AFFECTED) {
+			detail := getModificationDetail(g, n)
+			node.AddedCall, node.DeletedCall, node.AffectedCall = detail.AddedCall, detail.DeletedCall, detail.AffectedCall
+			node.RootCauses = detail.RootCauses
+		}
+		result.Nodes = append(result.Nodes, node)
+	}
+	edgeIDs := make(map[*DiffEdge]int)
+	for i, e := range vg.edges {
+		edgeIDs[e.edge] = i
+		edge := htmlGraphEdge{
+			From:        nodeIDs[e.from],
+			To:          nodeIDs[e.edge.Node],
+			Stroke:      svgColor(lineColorMap[e.edge.Difference]),
+			Dash:        edgeDash(e.edge),
+			Highlighted: e.highlighted,
+		}
+		if e.edge.Access == Writes {
+			edge.Label = Writes
+		}
+		result.Edges = append(result.Edges, edge)
+	}
+
+	for _, private := range []bool{false, true} {
+		for _, unchanged := range []bool{false, true} {
+			opts := *o
+			opts.PrintPrivate, opts.PrintUnchanged = private, unchanged
+			vg := g.visibleGraph(&opts)
+			l := layoutGraph(vg)
+			view := htmlGraphView{Width: l.width, Height: l.height}
+			for _, c := range l.clusters {
+				view.Clusters = append(view.Clusters, htmlGraphCluster{Label: c.label, Y: c.top, Height: c.height})
+			}
+			for _, n := range vg.nodes {
+				sn := l.nodes[n]
+				view.Nodes = append(view.Nodes, htmlNodeLayout{ID: nodeIDs[n], X: sn.x, Y: sn.y, W: sn.w, H: sn.h})
+			}
+			for _, e := range vg.edges {
+				path, labelX, labelY := edgeGeometry(l.nodes[e.from], l.nodes[e.edge.Node])
+				view.Edges = append(view.Edges, htmlEdgeLayout{ID: edgeIDs[e.edge], Path: path, LabelX: labelX, LabelY: labelY})
+			}
+			result.Views = append(result.Views, view)
+		}
+	}
+	return result
+}
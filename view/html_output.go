@@ -0,0 +1,416 @@
+package view
+
+import (
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+)
+
+// htmlDiffLine unified diff 中的一行及其样式
+type htmlDiffLine struct {
+	Class string
+	Text  string
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"diffLines": func(diff string) []htmlDiffLine {
+		var lines []htmlDiffLine
+		for _, line := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+			class := "context"
+			switch {
+			case strings.HasPrefix(line, "@@"):
+				class = "hunk"
+			case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+				class = "file"
+			case strings.HasPrefix(line, "+"):
+				class = "added"
+			case strings.HasPrefix(line, "-"):
+				class = "removed"
+			}
+			lines = append(lines, htmlDiffLine{Class: class, Text: line})
+		}
+		return lines
+	},
+	"join":    strings.Join,
+	"percent": func(f float64) float64 { return f * 100 },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>calldiff report</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #24292f; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #d0d7de; padding: 4px 8px; text-align: left; }
+.func { margin: 1.5em 0; border: 1px solid #d0d7de; border-radius: 6px; }
+.func h3 { margin: 0; padding: 8px 12px; background: #FFE6CC; font-family: monospace; }
+.func.affected h3 { background: #FFF2CD; }
+.func.moved h3 { background: #E1D5E7; }
+.func.global h3 { background: #F5F5F5; }
+.meta { padding: 4px 12px; font-size: 90%; }
+pre { margin: 0; padding: 8px 12px; overflow-x: auto; font-size: 85%; }
+pre span { display: block; }
+.added { background: #D5E8D4; }
+.removed { background: #F8CECC; }
+.hunk { color: #6e7781; background: #DAE8FC; }
+.file { font-weight: bold; }
+#viewer { display: flex; gap: 12px; margin: 1em 0; }
+#graph { flex: 1; height: 70vh; border: 1px solid #d0d7de; border-radius: 6px; cursor: grab; background: #FFFFFF; }
+#graph.dragging { cursor: grabbing; }
+#graph text { font-family: monospace; font-size: 12px; pointer-events: none; }
+#graph .node { cursor: pointer; }
+#graph .node.dim, #graph .edge.dim { opacity: 0.2; }
+#graph .node.match ellipse, #graph .node.match rect { stroke: #0969DA; stroke-width: 3; }
+#graph .node.selected ellipse, #graph .node.selected rect { stroke: #CF222E; stroke-width: 3; }
+#details { width: 32em; height: 70vh; overflow: auto; border: 1px solid #d0d7de; border-radius: 6px; padding: 0 12px; }
+#details h3 { font-family: monospace; word-break: break-all; }
+.toolbar label { margin-left: 1em; }
+</style>
+</head>
+<body>
+<h1>calldiff report</h1>
+<table>
+<tr><th>pkg</th><td>{{.Pkg}}</td></tr>
+<tr><th>algo</th><td>{{.Algo}}</td></tr>
+<tr><th>modified</th><td>{{len .ChangeList.Modified}}</td></tr>
+<tr><th>new</th><td>{{len .ChangeList.New}}</td></tr>
+<tr><th>deleted</th><td>{{len .ChangeList.Deleted}}</td></tr>
+<tr><th>moved</th><td>{{len .ChangeList.Moved}}</td></tr>
+<tr><th>globals</th><td>{{len .ChangeList.Globals}}</td></tr>
+<tr><th>types</th><td>{{len .ChangeList.TypeChanges}}</td></tr>
+</table>
+<h2>Graph</h2>
+<div class="toolbar">
+<input id="search" type="search" placeholder="search functions" size="40"> <span id="matches"></span>
+<label><input id="private" type="checkbox"> private</label>
+<label><input id="unchanged" type="checkbox"> unchanged</label>
+<button id="fit">fit</button>
+</div>
+<div id="viewer">
+<svg id="graph" xmlns="http://www.w3.org/2000/svg"><defs id="markers"></defs><g id="viewport"></g></svg>
+<div id="details"><p>Drag to pan, scroll to zoom, click a node to see its calls and source diff.</p></div>
+</div>
+{{range .ChangeList.Modified}}
+<div class="func{{if not .AstChanged}} affected{{end}}">
+<h3>{{.Name}}</h3>
+{{if .Changes}}<div class="meta">changes: {{join .Changes ", "}}</div>{{end}}
+{{if .RootCauses}}<div class="meta">root causes (distance {{.Distance}}): {{join .RootCauses ", "}}</div>{{end}}
+{{with .Source}}<div class="meta">{{.OldFile}}:{{.OldStartLine}}-{{.OldEndLine}} &rarr; {{.NewFile}}:{{.NewStartLine}}-{{.NewEndLine}}</div>
+{{if .Diff}}<pre>{{range diffLines .Diff}}<span class="{{.Class}}">{{.Text}}</span>{{end}}</pre>{{end}}{{end}}
+</div>
+{{end}}
+{{range .ChangeList.Moved}}
+<div class="func moved">
+<h3>{{.Name}}</h3>
+<div class="meta">{{.Kind}} from <code>{{.From}}</code>, confidence {{printf "%.0f%%" (percent .Confidence)}}</div>
+{{if .Changes}}<div class="meta">changes: {{join .Changes ", "}}</div>{{end}}
+{{with .Source}}{{if .Diff}}<pre>{{range diffLines .Diff}}<span class="{{.Class}}">{{.Text}}</span>{{end}}</pre>{{end}}{{end}}
+</div>
+{{end}}
+{{range .ChangeList.Globals}}
+<div class="func global">
+<h3>{{.Kind}} {{.Name}}</h3>
+<div class="meta">{{.Difference}}{{if .Readers}}, read by {{join .Readers ", "}}{{end}}</div>
+{{with .Source}}{{if .Diff}}<pre>{{range diffLines .Diff}}<span class="{{.Class}}">{{.Text}}</span>{{end}}</pre>{{end}}{{end}}
+</div>
+{{end}}
+{{range .ChangeList.TypeChanges}}
+<div class="func global">
+<h3>type {{.Name}}</h3>
+<div class="meta">{{.Difference}}{{if .Changes}}: {{join .Changes ", "}}{{end}}{{if .Users}}, used by {{join .Users ", "}}{{end}}</div>
+{{with .Source}}{{if .Diff}}<pre>{{range diffLines .Diff}}<span class="{{.Class}}">{{.Text}}</span>{{end}}</pre>{{end}}{{end}}
+</div>
+{{end}}
+{{if .ChangeList.Paths}}<h2>Call paths</h2>
+<table>{{range .ChangeList.Paths}}<tr><th><code>{{.Name}}</code> ({{.Difference}})</th><td>{{range .Chains}}<div><code>{{join . " &rarr; "}}</code></div>{{end}}</td></tr>{{end}}</table>{{end}}
+{{if .ChangeList.New}}<h2>New</h2>
+<ul>{{range .ChangeList.New}}<li><code>{{.}}</code></li>{{end}}</ul>{{end}}
+{{if .ChangeList.Deleted}}<h2>Deleted</h2>
+<ul>{{range .ChangeList.Deleted}}<li><code>{{.}}</code></li>{{end}}</ul>{{end}}
+<script>
+(function () {
+var data = {{.Graph}};
+var svgNS = "http://www.w3.org/2000/svg";
+var svg = document.getElementById("graph");
+var viewport = document.getElementById("viewport");
+var details = document.getElementById("details");
+var search = document.getElementById("search");
+var state = {scale: 1, x: 0, y: 0, selected: -1, view: null};
+document.getElementById("private").checked = data.private;
+document.getElementById("unchanged").checked = data.unchanged;
+
+function el(name, attrs, parent) {
+	var e = document.createElementNS(svgNS, name);
+	for (var k in attrs) {
+		e.setAttribute(k, attrs[k]);
+	}
+	if (parent) {
+		parent.appendChild(e);
+	}
+	return e;
+}
+function html(name, text, parent) {
+	var e = document.createElement(name);
+	if (text !== undefined) {
+		e.textContent = text;
+	}
+	parent.appendChild(e);
+	return e;
+}
+
+// 每种颜色的箭头
+var markers = {};
+data.edges.forEach(function (e) {
+	if (markers[e.stroke]) {
+		return;
+	}
+	markers[e.stroke] = "arrow" + Object.keys(markers).length;
+	var m = el("marker", {id: markers[e.stroke], viewBox: "0 0 10 10", refX: 10, refY: 5, markerWidth: 8, markerHeight: 8,
+		markerUnits: "userSpaceOnUse", orient: "auto"}, document.getElementById("markers"));
+	el("path", {d: "M0,0 L10,5 L0,10 z", fill: e.stroke}, m);
+});
+
+function transform() {
+	viewport.setAttribute("transform", "translate(" + state.x + "," + state.y + ") scale(" + state.scale + ")");
+}
+function fit() {
+	var v = state.view, r = svg.getBoundingClientRect();
+	state.scale = Math.min(r.width / v.width, r.height / v.height, 1);
+	state.x = (r.width - v.width * state.scale) / 2;
+	state.y = 0;
+	transform();
+}
+
+function render() {
+	var view = data.views[(document.getElementById("private").checked ? 2 : 0) + (document.getElementById("unchanged").checked ? 1 : 0)];
+	var first = state.view === null;
+	state.view = view;
+	while (viewport.firstChild) {
+		viewport.removeChild(viewport.firstChild);
+	}
+	(view.clusters || []).forEach(function (c) {
+		el("rect", {x: 20, y: c.y, width: view.width - 40, height: c.height, fill: "none", stroke: "#000000"}, viewport);
+		el("text", {x: view.width / 2, y: c.y + 20, "text-anchor": "middle"}, viewport).textContent = c.label;
+	});
+	(view.edges || []).forEach(function (layout) {
+		var e = data.edges[layout.id];
+		var g = el("g", {"class": "edge"}, viewport);
+		g.dataset.from = e.from;
+		g.dataset.to = e.to;
+		var attrs = {d: layout.path, fill: "none", stroke: e.stroke, "stroke-width": e.highlighted ? 3 : 1, "marker-end": "url(#" + markers[e.stroke] + ")"};
+		if (e.dash) {
+			attrs["stroke-dasharray"] = e.dash;
+		}
+		el("path", attrs, g);
+		if (e.label) {
+			el("text", {x: layout.label_x, y: layout.label_y, "text-anchor": "middle", fill: e.stroke}, g).textContent = e.label;
+		}
+	});
+	(view.nodes || []).forEach(function (layout) {
+		var n = data.nodes[layout.id];
+		var g = el("g", {"class": "node"}, viewport);
+		g.dataset.id = layout.id;
+		el("title", {}, g).textContent = n.title || n.name;
+		var shape = function (grow, fill) {
+			var width = n.highlighted ? 3 : 1;
+			if (n.kind) {
+				el("rect", {x: layout.x - layout.w / 2 - grow, y: layout.y - layout.h / 2 - grow, width: layout.w + 2 * grow,
+					height: layout.h + 2 * grow, fill: fill, stroke: n.stroke, "stroke-width": width}, g);
+			} else {
+				el("ellipse", {cx: layout.x, cy: layout.y, rx: layout.w / 2 + grow, ry: layout.h / 2 + grow, fill: fill,
+					stroke: n.stroke, "stroke-width": width}, g);
+			}
+		};
+		if (n.double) {
+			shape(4, "none");
+		}
+		shape(0, n.fill);
+		var top = layout.y - (n.label.length - 1) * 7 + 4;
+		n.label.forEach(function (line, i) {
+			el("text", {x: layout.x, y: top + i * 14, "text-anchor": "middle"}, g).textContent = line;
+		});
+		g.addEventListener("click", function (event) {
+			event.stopPropagation();
+			select(layout.id);
+		});
+	});
+	if (first) {
+		fit();
+	}
+	select(state.selected);
+	applySearch();
+}
+
+// 按函数名搜索，不匹配的节点与边变淡
+function applySearch() {
+	var query = search.value.trim().toLowerCase(), count = 0, matched = {};
+	viewport.querySelectorAll(".node").forEach(function (g) {
+		var match = query !== "" && data.nodes[g.dataset.id].name.toLowerCase().indexOf(query) >= 0;
+		matched[g.dataset.id] = match;
+		count += match ? 1 : 0;
+		g.classList.toggle("match", match);
+		g.classList.toggle("dim", query !== "" && !match);
+	});
+	viewport.querySelectorAll(".edge").forEach(function (g) {
+		g.classList.toggle("dim", query !== "" && !matched[g.dataset.from] && !matched[g.dataset.to]);
+	});
+	document.getElementById("matches").textContent = query === "" ? "" : count + " matches";
+}
+function centerOn(id) {
+	var r = svg.getBoundingClientRect();
+	state.view.nodes.forEach(function (layout) {
+		if (layout.id === id) {
+			state.x = r.width / 2 - layout.x * state.scale;
+			state.y = r.height / 2 - layout.y * state.scale;
+		}
+	});
+	transform();
+}
+
+function list(title, items) {
+	if (!items || items.length === 0) {
+		return;
+	}
+	html("h4", title, details);
+	var ul = html("ul", undefined, details);
+	items.forEach(function (item) {
+		html("code", item, html("li", undefined, ul));
+	});
+}
+function select(id) {
+	state.selected = id;
+	viewport.querySelectorAll(".node").forEach(function (g) {
+		g.classList.toggle("selected", Number(g.dataset.id) === id);
+	});
+	if (id < 0) {
+		return;
+	}
+	var n = data.nodes[id];
+	details.textContent = "";
+	html("h3", (n.kind ? n.kind + " " : "") + n.name, details);
+	html("p", n.difference + (n.moved_from ? " from " + n.moved_from : "") + (n.title ? ": " + n.title : ""), details);
+	list("changes", n.changes);
+	list("root causes", n.root_causes);
+	list("added calls", n.added_call);
+	list("deleted calls", n.deleted_call);
+	list("affected calls", (n.affected_call || []).map(function (c) {
+		return c.name + (c.affected_by.length ? " (by " + c.affected_by.join(", ") + ")" : "");
+	}));
+	var callers = [], callees = [];
+	data.edges.forEach(function (e) {
+		if (e.to === id) {
+			callers.push(data.nodes[e.from].name);
+		}
+		if (e.from === id) {
+			callees.push(data.nodes[e.to].name);
+		}
+	});
+	list("callers", callers);
+	list("callees", callees);
+	if (n.source && n.source.diff) {
+		html("h4", "source", details);
+		html("div", n.source.old_file + ":" + n.source.old_start_line + "-" + n.source.old_end_line + " \u2192 " +
+			n.source.new_file + ":" + n.source.new_start_line + "-" + n.source.new_end_line, details);
+		var pre = html("pre", undefined, details);
+		n.source.diff.replace(/\n$/, "").split("\n").forEach(function (line) {
+			var cls = "context";
+			if (line.indexOf("@@") === 0) {
+				cls = "hunk";
+			} else if (line.indexOf("---") === 0 || line.indexOf("+++") === 0) {
+				cls = "file";
+			} else if (line.charAt(0) === "+") {
+				cls = "added";
+			} else if (line.charAt(0) === "-") {
+				cls = "removed";
+			}
+			html("span", line, pre).className = cls;
+		});
+	}
+}
+
+// 拖动平移，滚轮以鼠标所在位置为中心缩放
+var drag = null;
+svg.addEventListener("mousedown", function (event) {
+	drag = {x: event.clientX - state.x, y: event.clientY - state.y};
+	svg.classList.add("dragging");
+});
+window.addEventListener("mousemove", function (event) {
+	if (drag) {
+		state.x = event.clientX - drag.x;
+		state.y = event.clientY - drag.y;
+		transform();
+	}
+});
+window.addEventListener("mouseup", function () {
+	drag = null;
+	svg.classList.remove("dragging");
+});
+svg.addEventListener("wheel", function (event) {
+	event.preventDefault();
+	var r = svg.getBoundingClientRect(), px = event.clientX - r.left, py = event.clientY - r.top;
+	var factor = Math.exp(-event.deltaY * 0.001);
+	state.x = px - (px - state.x) * factor;
+	state.y = py - (py - state.y) * factor;
+	state.scale *= factor;
+	transform();
+}, {passive: false});
+
+search.addEventListener("input", applySearch);
+search.addEventListener("keydown", function (event) {
+	if (event.key !== "Enter") {
+		return;
+	}
+	var match = viewport.querySelector(".node.match");
+	if (match) {
+		centerOn(Number(match.dataset.id));
+		select(Number(match.dataset.id));
+	}
+});
+document.getElementById("private").addEventListener("change", render);
+document.getElementById("unchanged").addEventListener("change", render);
+document.getElementById("fit").addEventListener("click", fit);
+render();
+})();
+</script>
+</body>
+</html>
+`))
+
+// htmlReportData HTML 报告的内容：JSON 输出中的差异列表，以及页面中绘制的差异图
+type htmlReportData struct {
+	Output
+	Graph *htmlGraph
+}
+
+// OutputHTML 将差异以 HTML 报告的形式写入 w，代码改变的函数附带源代码差异。
+// 报告是不依赖网络的单个文件，其中的差异图可以平移、缩放、按函数名搜索，点击节点查看其调用的变化与源代码差异，
+// 并可以在页面中切换是否显示未导出与未改变的函数
+func OutputHTML(w io.Writer, g *DiffGraph, o *common.DiffOptions) error {
+	output := NewOutput(g, o)
+	// 代码改变的函数在前，受影响的函数按距离由近到远排列
+	sort.SliceStable(output.ChangeList.Modified, func(i, j int) bool {
+		a, b := output.ChangeList.Modified[i], output.ChangeList.Modified[j]
+		if a.Distance != b.Distance {
+			return a.Distance < b.Distance
+		}
+		return a.Name < b.Name
+	})
+	sort.Strings(output.ChangeList.New)
+	sort.Strings(output.ChangeList.Deleted)
+	sort.Slice(output.ChangeList.Moved, func(i, j int) bool {
+		return output.ChangeList.Moved[i].Name < output.ChangeList.Moved[j].Name
+	})
+	sort.Slice(output.ChangeList.Globals, func(i, j int) bool {
+		return output.ChangeList.Globals[i].Name < output.ChangeList.Globals[j].Name
+	})
+	sort.Slice(output.ChangeList.Paths, func(i, j int) bool {
+		return output.ChangeList.Paths[i].Name < output.ChangeList.Paths[j].Name
+	})
+	sort.Slice(output.ChangeList.TypeChanges, func(i, j int) bool {
+		return output.ChangeList.TypeChanges[i].Name < output.ChangeList.TypeChanges[j].Name
+	})
+	return htmlReport.Execute(w, htmlReportData{Output: output, Graph: newHTMLGraph(g, o)})
+}
@@ -0,0 +1,46 @@
+package view
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+)
+
+func TestNewHTMLGraph(t *testing.T) {
+	g := chainGraph()
+	g.CalcAffected()
+	graph := newHTMLGraph(g, &common.DiffOptions{Pkg: "main", PrintPrivate: true})
+	// 节点包括未改变的 e，默认的布局中不显示
+	if len(graph.Nodes) != 6 || len(graph.Views) != 4 {
+		t.Fatalf("nodes %d, views %d", len(graph.Nodes), len(graph.Views))
+	}
+	visible := func(view htmlGraphView) []string {
+		var names []string
+		for _, n := range view.Nodes {
+			names = append(names, graph.Nodes[n.ID].Name)
+		}
+		return names
+	}
+	if names := strings.Join(visible(graph.Views[2]), ","); strings.Contains(names, "main.e") {
+		t.Errorf("private view shows unchanged node: %s", names)
+	}
+	if names := strings.Join(visible(graph.Views[3]), ","); !strings.Contains(names, "main.e") {
+		t.Errorf("private,unchanged view misses unchanged node: %s", names)
+	}
+	for _, n := range graph.Nodes {
+		if n.Name == "main.b" && !reflect.DeepEqual(n.RootCauses, []string{"main.c", "main.d"}) {
+			t.Errorf("b: root causes %v", n.RootCauses)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := OutputHTML(&b, g, &common.DiffOptions{Pkg: "main"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `"name":"main.main"`) {
+		t.Error("html report does not embed the graph")
+	}
+}
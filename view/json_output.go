@@ -3,12 +3,16 @@ package view
 import (
 	"encoding/json"
 	"fmt"
-	"io/fs"
-	"io/ioutil"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
 )
 
 type Output struct {
 	Pkg        string     `json:"pkg"`
+	Algo       string     `json:"algo"`
 	ChangeList changeList `json:"change_list"`
 }
 
@@ -17,6 +21,67 @@ type changeList struct {
 	New       []string      `json:"new"`
 	Deleted   []string      `json:"deleted"`
 	Unchanged []string      `json:"unchanged"`
+	Moved     []movedAPI    `json:"moved"`
+	Globals   []globalAPI   `json:"globals"`
+	// TypeChanges 类型的改变，用到改变的类型的函数与类型的 affected_by 中会列出它
+	TypeChanges []typeChangeAPI `json:"type_changes"`
+	// Paths 从 --path-roots 指定的入口函数到改变的函数的调用链
+	Paths []pathAPI `json:"paths"`
+	// Instantiations 泛型函数的实例的变化，只在指定 --instantiations 时输出
+	Instantiations []instantiationAPI `json:"instantiations,omitempty"`
+}
+
+type instantiationAPI struct {
+	Name    string   `json:"name"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// globalAPI 全局变量或常量的改变，readers 为直接读取它的函数，这些函数的 affected_by 中会列出它
+type globalAPI struct {
+	Name       string      `json:"name"`
+	Kind       string      `json:"kind"`       // var 或 const
+	Difference string      `json:"difference"` // changed、new、deleted 或 unchanged
+	Source     *SourceDiff `json:"source"`
+	Readers    []string    `json:"readers"`
+	Writers    []string    `json:"writers"`
+}
+
+// typeChangeAPI 类型的改变，users 为签名或函数体中直接用到它的函数，以及字段或底层类型中用到它的类型
+type typeChangeAPI struct {
+	Name       string      `json:"name"`
+	Difference string      `json:"difference"` // changed、affected、new、deleted 或 unchanged
+	Changes    []string    `json:"changes"`    // 如 fields_added、tags_changed、methods_removed
+	TypeChange             // 改变的字段与方法
+	Source     *SourceDiff `json:"source"`
+	Users      []string    `json:"users"`
+}
+
+// differenceName globalAPI、typeChangeAPI 与 pathAPI 中 Difference 的取值
+var differenceName = map[DiffType]string{
+	UNCHANGED: "unchanged",
+	INSERTED:  "new",
+	REMOVED:   "deleted",
+	CHANGED:   "changed",
+	AFFECTED:  "affected", // 只用于类型：用到的其他类型改变
+	MOVED:     "moved",
+	RENAMED:   "renamed",
+}
+
+// pathAPI 从入口函数到改变的函数的调用链，按长度从短到长排列
+type pathAPI struct {
+	Name       string     `json:"name"`
+	Difference string     `json:"difference"`
+	Chains     [][]string `json:"chains"` // 每条调用链以入口函数开头、以该函数结尾
+}
+
+type movedAPI struct {
+	Name       string      `json:"name"`
+	From       string      `json:"from"`
+	Kind       string      `json:"kind"`       // moved 或 renamed
+	Confidence float64     `json:"confidence"` // 配对的置信度，取值范围为 [0, 1]
+	Changes    []string    `json:"changes"`    // 移动或重命名时代码同时改变的内容
+	Source     *SourceDiff `json:"source"`
 }
 
 type modifiedAPI struct {
@@ -25,6 +90,11 @@ type modifiedAPI struct {
 	DeletedCall  []string       `json:"deleted_call"`
 	AffectedCall []affectedCall `json:"affected_call"`
 	AstChanged   bool           `json:"ast_changed"`
+	Changes      []string       `json:"changes"`
+	Source       *SourceDiff    `json:"source"`
+	Distance     int            `json:"distance"`
+	RootCauses   []string       `json:"root_causes"`
+	Reasons      []string       `json:"reasons"`
 }
 
 type affectedCall struct {
@@ -32,14 +102,51 @@ type affectedCall struct {
 	AffectedBy []string `json:"affected_by"`
 }
 
-func OutputJSON(g *DiffGraph, doPrintPrivate bool, doPrintUnchanged bool, pkg string) error {
-	var o Output
-	o.Pkg = pkg
+// OutputJSON 将差异以 JSON 格式写入 w
+func OutputJSON(w io.Writer, g *DiffGraph, o *common.DiffOptions) error {
+	return writeJSON(w, NewOutput(g, o))
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	marshal, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(marshal)
+	return err
+}
+
+// NewOutput 整理出 JSON 输出所需的差异列表
+func NewOutput(g *DiffGraph, options *common.DiffOptions) (o Output) {
+	o.Pkg = options.Pkg
+	o.Algo = options.Algo
+	filter := g.PkgFilter(options)
 	for _, node := range g.Nodes {
-		if node.GetPkgName() == pkg {
-			if !doPrintPrivate && node.IsPrivate() {
+		if node.InPackages(filter) {
+			if !options.PrintPrivate && node.IsPrivate() {
+				continue
+			}
+			if node.Kind != "" {
+				if node.Difference == UNCHANGED && !options.PrintUnchanged {
+					continue
+				}
+				if node.Kind == NamedType {
+					o.ChangeList.TypeChanges = append(o.ChangeList.TypeChanges, getTypeChangeDetail(g, node))
+				} else {
+					o.ChangeList.Globals = append(o.ChangeList.Globals, getGlobalDetail(g, node))
+				}
 				continue
 			}
+			if len(node.Paths) > 0 {
+				o.ChangeList.Paths = append(o.ChangeList.Paths, getPathDetail(g, node))
+			}
+			if options.ReportInstantiations && (len(node.InstancesAdded) > 0 || len(node.InstancesRemoved) > 0) {
+				o.ChangeList.Instantiations = append(o.ChangeList.Instantiations, instantiationAPI{
+					Name:    node.GetPrettyName(),
+					Added:   node.InstancesAdded,
+					Removed: node.InstancesRemoved,
+				})
+			}
 			switch node.Difference {
 			case INSERTED:
 				if o.ChangeList.New == nil {
@@ -53,8 +160,21 @@ func OutputJSON(g *DiffGraph, doPrintPrivate bool, doPrintUnchanged bool, pkg st
 				o.ChangeList.Deleted = append(o.ChangeList.Deleted, node.GetPrettyName())
 			case CHANGED, AFFECTED:
 				o.ChangeList.Modified = append(o.ChangeList.Modified, getModificationDetail(g, node))
+			case MOVED, RENAMED:
+				kind := "moved"
+				if node.Difference == RENAMED {
+					kind = "renamed"
+				}
+				o.ChangeList.Moved = append(o.ChangeList.Moved, movedAPI{
+					Name:       node.GetPrettyName(),
+					From:       prettyName(node.MovedFrom),
+					Kind:       kind,
+					Confidence: math.Round(node.Confidence*100) / 100,
+					Changes:    node.Changes,
+					Source:     node.Source,
+				})
 			case UNCHANGED:
-				if doPrintUnchanged {
+				if options.PrintUnchanged {
 					if o.ChangeList.Unchanged == nil {
 						o.ChangeList.Unchanged = []string{}
 					}
@@ -63,26 +183,27 @@ func OutputJSON(g *DiffGraph, doPrintPrivate bool, doPrintUnchanged bool, pkg st
 			}
 		}
 	}
-	marshal, err := json.MarshalIndent(o, "", "    ")
-	if err != nil {
-		return err
-	}
-	if err := ioutil.WriteFile("./output/difference.json", marshal, fs.ModePerm); err != nil {
-		return err
-	}
-	return nil
+	return o
 }
 
 func getModificationDetail(g *DiffGraph, node *DiffNode) (result modifiedAPI) {
 	result.Name = node.GetPrettyName()
+	result.Distance = node.Distance
+	result.RootCauses = rootCauses(g, node)
+	result.Reasons = rootCauseReasons(g, node)
+	result.Changes = node.Changes
+	result.Source = node.Source
 	if node.Difference == CHANGED {
-		result.AstChanged = true
+		result.AstChanged = node.Reason != CallStructureChanged
 	} else if node.Difference == AFFECTED {
 		result.AstChanged = false
 	} else {
 		fmt.Println("error")
 	}
 	for _, edge := range node.CallEdge {
+		if edge.Access != "" && edge.Difference != CHANGED {
+			continue // 新增或不再访问的全局变量、类型不是调用的增删
+		}
 		switch edge.Difference {
 		case INSERTED:
 			if result.AddedCall == nil {
@@ -118,6 +239,59 @@ func getModificationDetail(g *DiffGraph, node *DiffNode) (result modifiedAPI) {
 	return result
 }
 
+// getGlobalDetail 整理全局变量或常量的改变，以及读写它的函数
+func getGlobalDetail(g *DiffGraph, node *DiffNode) globalAPI {
+	return globalAPI{
+		Name:       node.GetPrettyName(),
+		Kind:       node.Kind,
+		Difference: differenceName[node.Difference],
+		Source:     node.Source,
+		Readers:    accessors(g, node, Reads),
+		Writers:    accessors(g, node, Writes),
+	}
+}
+
+// getTypeChangeDetail 整理类型的改变，以及用到它的函数与类型
+func getTypeChangeDetail(g *DiffGraph, node *DiffNode) typeChangeAPI {
+	result := typeChangeAPI{
+		Name:       node.GetPrettyName(),
+		Difference: differenceName[node.Difference],
+		Changes:    node.Changes,
+		Source:     node.Source,
+		Users:      accessors(g, node, Uses),
+	}
+	if node.TypeChange != nil {
+		result.TypeChange = *node.TypeChange
+	}
+	return result
+}
+
+// getPathDetail 将调用链中的函数转换为输出的名称
+func getPathDetail(g *DiffGraph, node *DiffNode) pathAPI {
+	result := pathAPI{Name: node.GetPrettyName(), Difference: differenceName[node.Difference]}
+	for _, path := range node.Paths {
+		chain := make([]string, 0, len(path))
+		for _, name := range path {
+			chain = append(chain, prettyName(name))
+		}
+		result.Chains = append(result.Chains, chain)
+	}
+	return result
+}
+
+// accessors 返回新版本中以 access 方式访问 node 的节点，按名称排列
+func accessors(g *DiffGraph, node *DiffNode, access string) []string {
+	result := []string{}
+	for _, caller := range g.Nodes {
+		edge, ok := caller.CallEdge[node.Name]
+		if ok && edge.Difference != REMOVED && edge.Access == access {
+			result = append(result, caller.GetPrettyName())
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
 func findAffectedBy(node *DiffNode, flags map[*DiffNode]bool, result *[]*DiffNode) {
 	if flags[node] {
 		return
@@ -133,3 +307,41 @@ func findAffectedBy(node *DiffNode, flags map[*DiffNode]bool, result *[]*DiffNod
 		}
 	}
 }
+
+// rootCauses 按跳数从近到远列出影响到 node 的代码改变的函数
+func rootCauses(g *DiffGraph, node *DiffNode) []string {
+	names := make([]string, 0, len(node.RootCauses))
+	for name := range node.RootCauses {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		di, dj := node.RootCauses[names[i]], node.RootCauses[names[j]]
+		if di != dj {
+			return di < dj
+		}
+		return names[i] < names[j]
+	})
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		if cause, ok := g.Nodes[name]; ok {
+			result = append(result, cause.GetPrettyName())
+		}
+	}
+	return result
+}
+
+// rootCauseReasons 列出影响到 node 的改变的原因，即各个根因本身改变的原因
+func rootCauseReasons(g *DiffGraph, node *DiffNode) []string {
+	reasons := make([]string, 0)
+	seen := make(map[string]bool)
+	for name := range node.RootCauses {
+		cause, ok := g.Nodes[name]
+		if !ok || cause.Reason == "" || seen[cause.Reason] {
+			continue
+		}
+		seen[cause.Reason] = true
+		reasons = append(reasons, cause.Reason)
+	}
+	sort.Strings(reasons)
+	return reasons
+}
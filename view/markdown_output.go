@@ -0,0 +1,289 @@
+package view
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+)
+
+// markdownTruncated 报告超出字符上限时附加在末尾的说明
+const markdownTruncated = "\n_The report was truncated to fit in %d characters, see the JSON output for the full list._\n"
+
+// markdownMorePackages 按包统计的表格超出字符上限时代替省略的行
+const markdownMorePackages = "| … and %d more packages | | | | | |\n"
+
+// markdownSection 报告中可折叠的一节，超出字符上限时从末尾开始省略其中的条目
+type markdownSection struct {
+	title string
+	items []string
+	open  bool // 默认展开
+}
+
+// markdownPackage 一个包中各种差异的函数的个数
+type markdownPackage struct {
+	path                                        string
+	inserted, removed, changed, affected, moved int
+}
+
+// OutputMarkdown 将差异以适合贴在合并请求评论中的 Markdown 写入 w：
+// 按包统计的表格、可折叠的函数列表、从入口函数出发的调用链，以及改变的部分的 Mermaid 流程图。
+// o.MarkdownBudget 大于 0 时报告不超过该字符数，放不下的条目与流程图会被省略
+func OutputMarkdown(w io.Writer, g *DiffGraph, o *common.DiffOptions) error {
+	output := NewOutput(g, o)
+	list := output.ChangeList
+	var b strings.Builder
+	fmt.Fprintf(&b, "## calldiff report\n\nPackage: `%s`, algorithm: `%s`\n\n", output.Pkg, output.Algo)
+	b.WriteString("| Package | New | Deleted | Changed | Affected | Moved |\n")
+	b.WriteString("| ------- | --- | ------- | ------- | -------- | ----- |\n")
+	budget := o.MarkdownBudget
+	// 为省略时附加的说明留出空间
+	remaining := func() int {
+		return budget - utf8.RuneCountInString(b.String()) - utf8.RuneCountInString(fmt.Sprintf(markdownTruncated, budget))
+	}
+	truncated := false
+
+	packages := markdownPackages(g, o)
+	var total markdownPackage
+	for _, p := range packages {
+		total.inserted += p.inserted
+		total.removed += p.removed
+		total.changed += p.changed
+		total.affected += p.affected
+		total.moved += p.moved
+	}
+	totalRow := fmt.Sprintf("| **Total** | %d | %d | %d | %d | %d |\n", total.inserted, total.removed, total.changed, total.affected, total.moved)
+	rows := make([]string, len(packages))
+	for i, p := range packages {
+		rows[i] = fmt.Sprintf("| `%s` | %d | %d | %d | %d | %d |\n", p.path, p.inserted, p.removed, p.changed, p.affected, p.moved)
+	}
+	// 表格中的行同样计入字符上限，放不下的包合并为一行，总计一行总是保留。
+	// 输出前 n 行时，其后实际写入的是省略其余 len(rows)-n 个包的一行（n 为全部行时没有），按这一行预留空间
+	n := len(rows)
+	if budget > 0 {
+		used := utf8.RuneCountInString(totalRow)
+		for n = 0; n < len(rows); n++ {
+			more := ""
+			if n+1 < len(rows) {
+				more = fmt.Sprintf(markdownMorePackages, len(rows)-n-1)
+			}
+			if used+utf8.RuneCountInString(rows[n]+more) > remaining() {
+				break
+			}
+			used += utf8.RuneCountInString(rows[n])
+		}
+	}
+	for _, row := range rows[:n] {
+		b.WriteString(row)
+	}
+	if n < len(rows) {
+		fmt.Fprintf(&b, markdownMorePackages, len(rows)-n)
+		truncated = true
+	}
+	b.WriteString(totalRow)
+
+	// 调用结构改变的函数同样是代码改变，距离为 0
+	var changed, affected []modifiedAPI
+	for _, m := range list.Modified {
+		if m.Distance == 0 {
+			changed = append(changed, m)
+		} else {
+			affected = append(affected, m)
+		}
+	}
+	sort.Slice(affected, func(i, j int) bool {
+		if affected[i].Distance != affected[j].Distance {
+			return affected[i].Distance < affected[j].Distance
+		}
+		return affected[i].Name < affected[j].Name
+	})
+	sections := []markdownSection{
+		{title: "Impacted entry points", items: markdownPaths(list.Paths), open: true},
+		{title: "Changed functions", items: markdownChanged(changed), open: true},
+		{title: "New functions", items: markdownNames(list.New)},
+		{title: "Deleted functions", items: markdownNames(list.Deleted)},
+		{title: "Moved and renamed functions", items: markdownMoved(list.Moved)},
+		{title: "Changed globals and types", items: markdownGlobals(list.Globals, list.TypeChanges)},
+		{title: "Affected functions", items: markdownAffected(affected)},
+	}
+
+	for _, s := range sections {
+		if len(s.items) == 0 {
+			continue
+		}
+		if budget > 0 {
+			limit := remaining()
+			if limit < 0 {
+				limit = 0
+			}
+			truncated = s.write(&b, limit) || truncated
+		} else {
+			s.write(&b, -1)
+		}
+	}
+	chart := "\n<details><summary>Call graph</summary>\n\n```mermaid\n" + g.mermaidFlowchart(o) + "```\n\n</details>\n"
+	if budget <= 0 || utf8.RuneCountInString(chart) <= remaining() {
+		b.WriteString(chart)
+	} else {
+		truncated = true
+	}
+	if truncated {
+		fmt.Fprintf(&b, markdownTruncated, budget)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// write 将这一节写入 b，limit 不小于 0 时最多写入 limit 个字符，返回是否省略了条目
+func (s *markdownSection) write(b *strings.Builder, limit int) bool {
+	open := ""
+	if s.open {
+		open = " open"
+	}
+	head := fmt.Sprintf("\n<details%s><summary>%s (%d)</summary>\n\n", open, s.title, len(s.items))
+	const tail = "\n</details>\n"
+	if limit < 0 {
+		b.WriteString(head + strings.Join(s.items, "") + tail)
+		return false
+	}
+	used := utf8.RuneCountInString(head) + utf8.RuneCountInString(tail)
+	n := 0
+	for ; n < len(s.items); n++ {
+		// 除最后一条外，为省略的条数留出空间
+		more := 0
+		if n < len(s.items)-1 {
+			more = utf8.RuneCountInString(fmt.Sprintf("- … and %d more\n", len(s.items)-n-1))
+		}
+		size := utf8.RuneCountInString(s.items[n])
+		if used+size+more > limit {
+			break
+		}
+		used += size
+	}
+	if n == 0 {
+		return true // 连一条也放不下时省略整节
+	}
+	b.WriteString(head + strings.Join(s.items[:n], ""))
+	if n < len(s.items) {
+		fmt.Fprintf(b, "- … and %d more\n", len(s.items)-n)
+	}
+	b.WriteString(tail)
+	return n < len(s.items)
+}
+
+// markdownPackages 按包统计 --pkg 选中的函数中各种差异的个数，不统计没有改变的包
+func markdownPackages(g *DiffGraph, o *common.DiffOptions) []markdownPackage {
+	filter := g.PkgFilter(o)
+	packages := make(map[string]*markdownPackage)
+	for _, node := range g.Nodes {
+		if node.Kind != "" || !node.InPackages(filter) || (!o.PrintPrivate && node.IsPrivate()) {
+			continue
+		}
+		p, ok := packages[node.GetPath()]
+		if !ok {
+			p = &markdownPackage{path: node.GetPath()}
+		}
+		switch node.Difference {
+		case INSERTED:
+			p.inserted++
+		case REMOVED:
+			p.removed++
+		case CHANGED:
+			p.changed++
+		case AFFECTED:
+			p.affected++
+		case MOVED, RENAMED:
+			p.moved++
+		default:
+			continue
+		}
+		packages[p.path] = p
+	}
+	result := make([]markdownPackage, 0, len(packages))
+	for _, p := range packages {
+		result = append(result, *p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].path < result[j].path })
+	return result
+}
+
+func markdownNames(names []string) []string {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	items := make([]string, 0, len(sorted))
+	for _, name := range sorted {
+		items = append(items, fmt.Sprintf("- `%s`\n", name))
+	}
+	return items
+}
+
+func markdownChanged(changed []modifiedAPI) []string {
+	sort.Slice(changed, func(i, j int) bool { return changed[i].Name < changed[j].Name })
+	items := make([]string, 0, len(changed))
+	for _, m := range changed {
+		item := fmt.Sprintf("- `%s`", m.Name)
+		if len(m.Changes) > 0 {
+			item += ": " + strings.Join(m.Changes, ", ")
+		}
+		if len(m.AddedCall) > 0 {
+			item += fmt.Sprintf(", %d calls added", len(m.AddedCall))
+		}
+		if len(m.DeletedCall) > 0 {
+			item += fmt.Sprintf(", %d calls deleted", len(m.DeletedCall))
+		}
+		items = append(items, item+"\n")
+	}
+	return items
+}
+
+func markdownAffected(affected []modifiedAPI) []string {
+	items := make([]string, 0, len(affected))
+	for _, m := range affected {
+		causes := make([]string, 0, len(m.RootCauses))
+		for _, cause := range m.RootCauses {
+			causes = append(causes, "`"+cause+"`")
+		}
+		items = append(items, fmt.Sprintf("- `%s` (distance %d) affected by %s\n", m.Name, m.Distance, strings.Join(causes, ", ")))
+	}
+	return items
+}
+
+func markdownMoved(moved []movedAPI) []string {
+	sort.Slice(moved, func(i, j int) bool { return moved[i].Name < moved[j].Name })
+	items := make([]string, 0, len(moved))
+	for _, m := range moved {
+		items = append(items, fmt.Sprintf("- `%s` %s from `%s` (%.0f%%)\n", m.Name, m.Kind, m.From, m.Confidence*100))
+	}
+	return items
+}
+
+func markdownGlobals(globals []globalAPI, typeChanges []typeChangeAPI) []string {
+	var items []string
+	for _, global := range globals {
+		items = append(items, fmt.Sprintf("- %s `%s` %s\n", global.Kind, global.Name, global.Difference))
+	}
+	for _, t := range typeChanges {
+		item := fmt.Sprintf("- type `%s` %s", t.Name, t.Difference)
+		if len(t.Changes) > 0 {
+			item += ": " + strings.Join(t.Changes, ", ")
+		}
+		items = append(items, item+"\n")
+	}
+	sort.Strings(items)
+	return items
+}
+
+// markdownPaths 列出从入口函数到各个改变的函数的调用链
+func markdownPaths(paths []pathAPI) []string {
+	sort.Slice(paths, func(i, j int) bool { return paths[i].Name < paths[j].Name })
+	var items []string
+	for _, p := range paths {
+		for _, chain := range p.Chains {
+			items = append(items, fmt.Sprintf("- `%s` (%s)\n", strings.Join(chain, " → "), p.Difference))
+		}
+	}
+	return items
+}
@@ -0,0 +1,134 @@
+package view
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+)
+
+func TestOutputMarkdown(t *testing.T) {
+	g := chainGraph()
+	g.CalcAffected()
+	g.Nodes[node(g, "c").Name].Paths = [][]string{{node(g, "main").Name, node(g, "a").Name, node(g, "b").Name, node(g, "c").Name}}
+
+	var b bytes.Buffer
+	o := &common.DiffOptions{Pkg: "main", PrintPrivate: true}
+	if err := OutputMarkdown(&b, g, o); err != nil {
+		t.Fatal(err)
+	}
+	report := b.String()
+	for _, want := range []string{
+		"| `example.com/m` | 0 | 0 | 2 | 3 | 0 |",
+		"<summary>Changed functions (2)</summary>",
+		"<summary>Affected functions (3)</summary>",
+		"- `main.main → main.a → main.b → main.c` (changed)",
+		"```mermaid\nflowchart LR\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report does not contain %q:\n%s", want, report)
+		}
+	}
+	if strings.Contains(report, "truncated") {
+		t.Errorf("unlimited report is truncated:\n%s", report)
+	}
+
+	// 超出字符上限时省略条目与流程图
+	b.Reset()
+	o.MarkdownBudget = 700
+	if err := OutputMarkdown(&b, g, o); err != nil {
+		t.Fatal(err)
+	}
+	report = b.String()
+	if n := utf8.RuneCountInString(report); n > o.MarkdownBudget {
+		t.Errorf("report has %d characters, budget %d:\n%s", n, o.MarkdownBudget, report)
+	}
+	if !strings.Contains(report, "truncated to fit in 700 characters") || strings.Contains(report, "mermaid") {
+		t.Errorf("report is not truncated:\n%s", report)
+	}
+}
+
+func TestOutputMarkdownPackages(t *testing.T) {
+	// 包很多时按包统计的表格同样受字符上限约束
+	g := NewDiffGraphHelper()
+	for i := 0; i < 20; i++ {
+		node := NewDiffNodeHelper()
+		node.Name = fmt.Sprintf("example.com/m/package%02d#package%02d#F#", i, i)
+		node.Difference = CHANGED
+		g.Nodes[node.Name] = node
+	}
+	var b bytes.Buffer
+	o := &common.DiffOptions{Pkg: "example.com/m/...", MarkdownBudget: 600}
+	if err := OutputMarkdown(&b, g, o); err != nil {
+		t.Fatal(err)
+	}
+	report := b.String()
+	if n := utf8.RuneCountInString(report); n > o.MarkdownBudget {
+		t.Errorf("report has %d characters, budget %d:\n%s", n, o.MarkdownBudget, report)
+	}
+	for _, want := range []string{"| `example.com/m/package00` | 0 | 0 | 1 | 0 | 0 |", "more packages |", "| **Total** | 0 | 0 | 20 | 0 | 0 |", "truncated to fit in 600 characters"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report does not contain %q:\n%s", want, report)
+		}
+	}
+
+	// 逐个字符地收紧上限，省略的行数与写入的行数之和始终等于包的个数，报告不超出上限
+	cases := []struct {
+		name  string
+		paths []string
+	}{
+		// 最后一个包的行比省略包的一行更短
+		{"short last row", []string{"example.com/m/package00", "example.com/m/package01", "example.com/m/package02", "m/z"}},
+		// 省略的包数从 10 变为 9 时少一位
+		{"10 packages", nil},
+	}
+	for i := 0; i < 10; i++ {
+		cases[1].paths = append(cases[1].paths, fmt.Sprintf("example.com/m/package%02d", i))
+	}
+	for _, c := range cases {
+		g := NewDiffGraphHelper()
+		for _, path := range c.paths {
+			node := NewDiffNodeHelper()
+			node.Name = path + "#p#F#"
+			node.Difference = CHANGED
+			g.Nodes[node.Name] = node
+		}
+		o := &common.DiffOptions{Pkg: "example.com/m/...,m/..."}
+		var full bytes.Buffer
+		if err := OutputMarkdown(&full, g, o); err != nil {
+			t.Fatal(err)
+		}
+		// 一个包都放不下时表格本身已超出上限，不再继续收紧
+		for o.MarkdownBudget = utf8.RuneCountInString(full.String()); ; o.MarkdownBudget-- {
+			var b bytes.Buffer
+			if err := OutputMarkdown(&b, g, o); err != nil {
+				t.Fatal(err)
+			}
+			report := b.String()
+			rows := 0
+			for _, path := range c.paths {
+				if strings.Contains(report, "| `"+path+"` |") {
+					rows++
+				}
+			}
+			if rows == 0 {
+				break
+			}
+			if n := utf8.RuneCountInString(report); n > o.MarkdownBudget {
+				t.Fatalf("%s: report has %d characters, budget %d:\n%s", c.name, n, o.MarkdownBudget, report)
+			}
+			more := 0
+			if i := strings.Index(report, "| … and "); i >= 0 {
+				if _, err := fmt.Sscanf(report[i:], "| … and %d more packages", &more); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if rows+more != len(c.paths) {
+				t.Fatalf("%s: %d rows and %d more packages, want %d packages in total:\n%s", c.name, rows, more, len(c.paths), report)
+			}
+		}
+	}
+}
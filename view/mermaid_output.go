@@ -0,0 +1,96 @@
+package view
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+)
+
+// mermaidEscaper 转义 Mermaid 节点标签中的特殊字符，换行转换为 <br>
+var mermaidEscaper = strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;", "\n", "<br>")
+
+// MermaidVisualization 将差异以 Mermaid 流程图的形式写入 w
+func (g *DiffGraph) MermaidVisualization(w io.Writer, o *common.DiffOptions) error {
+	_, err := io.WriteString(w, g.mermaidFlowchart(o))
+	return err
+}
+
+// mermaidFlowchart 返回差异的 Mermaid 流程图，节点与边的可见性与 Visualization 一致。
+// 每个包为一个 subgraph，节点按差异种类使用不同的 class，边的颜色与样式由 linkStyle 指定
+func (g *DiffGraph) mermaidFlowchart(o *common.DiffOptions) string {
+	vg := g.visibleGraph(o)
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	differences := sortedDifferences()
+	for _, difference := range differences {
+		fmt.Fprintf(&b, "    classDef %s fill:%s,stroke:%s\n", differenceName[difference],
+			svgColor(fillColorMap[difference]), svgColor(lineColorMap[difference]))
+	}
+
+	ids := make(map[*DiffNode]string)
+	for i, n := range vg.nodes {
+		ids[n] = "n" + strconv.Itoa(i)
+	}
+	paths, clusters := vg.packages()
+	classes := make(map[string][]string)
+	for i, path := range paths {
+		nodes := clusters[path]
+		fmt.Fprintf(&b, "    subgraph p%d [\"%s\"]\n", i, mermaidEscaper.Replace(nodes[0].GetPkgName()+" ("+path+")"))
+		for _, n := range nodes {
+			label := mermaidEscaper.Replace(n.label())
+			switch {
+			case nodeStyleMap[n.Difference] != "":
+				// 移动或重命名的函数使用两侧带竖线的形状，相当于双线边框
+				fmt.Fprintf(&b, "        %s[[\"%s\"]]\n", ids[n], label)
+			case n.Kind != "":
+				fmt.Fprintf(&b, "        %s[\"%s\"]\n", ids[n], label)
+			default:
+				fmt.Fprintf(&b, "        %s(\"%s\")\n", ids[n], label)
+			}
+			classes[differenceName[n.Difference]] = append(classes[differenceName[n.Difference]], ids[n])
+		}
+		b.WriteString("    end\n")
+	}
+
+	// 颜色与样式相同的边合并为一条 linkStyle
+	var styles []string
+	links := make(map[string][]string)
+	for i, e := range vg.edges {
+		arrow := "-->"
+		if e.edge.Access != "" || e.edge.Difference == REMOVED {
+			arrow = "-.->"
+		}
+		if e.edge.Access == Writes {
+			arrow = "-. " + Writes + " .->"
+		}
+		fmt.Fprintf(&b, "    %s %s %s\n", ids[e.from], arrow, ids[e.edge.Node])
+		style := "stroke:" + svgColor(lineColorMap[e.edge.Difference])
+		if dash := edgeDash(e.edge); dash != "" {
+			style += ",stroke-dasharray:" + strings.ReplaceAll(dash, ",", " ")
+		}
+		if e.highlighted {
+			style += ",stroke-width:3px"
+		}
+		if _, ok := links[style]; !ok {
+			styles = append(styles, style)
+		}
+		links[style] = append(links[style], strconv.Itoa(i))
+	}
+	for _, style := range styles {
+		fmt.Fprintf(&b, "    linkStyle %s %s\n", strings.Join(links[style], ","), style)
+	}
+	for _, difference := range differences {
+		if nodes := classes[differenceName[difference]]; len(nodes) > 0 {
+			fmt.Fprintf(&b, "    class %s %s\n", strings.Join(nodes, ","), differenceName[difference])
+		}
+	}
+	for _, n := range vg.nodes {
+		if vg.highlighted[n] {
+			fmt.Fprintf(&b, "    style %s stroke-width:3px\n", ids[n])
+		}
+	}
+	return b.String()
+}
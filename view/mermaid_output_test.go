@@ -0,0 +1,42 @@
+package view
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+)
+
+// removedCallGraph 在 chainGraph 的基础上删去调用 main -> e
+func removedCallGraph() *DiffGraph {
+	g := chainGraph()
+	g.CalcAffected()
+	g.Nodes[node(g, "main").Name].CallEdge[node(g, "e").Name].Difference = REMOVED
+	return g
+}
+
+func TestMermaidVisualization(t *testing.T) {
+	g := removedCallGraph()
+	var b bytes.Buffer
+	if err := g.MermaidVisualization(&b, &common.DiffOptions{Pkg: "main", PrintPrivate: true}); err != nil {
+		t.Fatal(err)
+	}
+	chart := b.String()
+	for _, want := range []string{
+		"flowchart LR\n",
+		"classDef changed fill:#FFE6CC,stroke:#D79B00\n",
+		"subgraph p0 [\"main (example.com/m)\"]\n",
+		"stroke:#B85450,stroke-dasharray:6 4\n",
+		"class n0,n1,n5 affected\n",
+		"class n2,n3 changed\n",
+	} {
+		if !strings.Contains(chart, want) {
+			t.Errorf("chart does not contain %q:\n%s", want, chart)
+		}
+	}
+	// 删去的调用使 e 可见，与 Visualization 一致
+	if !strings.Contains(chart, `n4("e")`) {
+		t.Errorf("chart misses e:\n%s", chart)
+	}
+}
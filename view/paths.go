@@ -0,0 +1,224 @@
+package view
+
+import (
+	"container/heap"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+)
+
+// 调用链的入口函数的种类
+const (
+	RootMain     = "main"     // main 包的 main 函数
+	RootExported = "exported" // --pkg 选中的包（main 包除外）中导出的函数与方法
+	RootHandlers = "handlers" // HTTP 处理函数，如 func(http.ResponseWriter, *http.Request)、func(*gin.Context)
+	RootTests    = "tests"    // 测试、基准测试、模糊测试与示例函数，需要 --test
+)
+
+// PathRoots 支持的入口函数的种类
+var PathRoots = []string{RootMain, RootExported, RootHandlers, RootTests}
+
+// maxPathSearch 为每个函数寻找调用链时最多展开的部分调用链的个数，避免在稠密的调用图中耗时过长
+const maxPathSearch = 100000
+
+// ParsePathRoots 解析逗号分隔的入口函数的种类，为空时返回 nil
+func ParsePathRoots(roots string) ([]string, error) {
+	var result []string
+	for _, root := range strings.Split(roots, ",") {
+		root = strings.TrimSpace(root)
+		if root == "" {
+			continue
+		}
+		supported := false
+		for _, r := range PathRoots {
+			supported = supported || r == root
+		}
+		if !supported {
+			return nil, fmt.Errorf("unsupported path root %q, supported are %s", root, strings.Join(PathRoots, ", "))
+		}
+		result = append(result, root)
+	}
+	return result, nil
+}
+
+// isRoot 判断节点是否为 roots 中某种入口函数
+func (g *DiffGraph) isRoot(n *DiffNode, roots []string, filter *common.PkgFilter) bool {
+	if n.Kind != "" || !g.inModule(n) {
+		return false
+	}
+	name := strings.Split(n.Name, "#")[2]
+	for _, root := range roots {
+		switch root {
+		case RootMain:
+			if n.GetPkgName() == "main" && n.GetFuncName() == "main" {
+				return true
+			}
+		case RootExported:
+			if n.GetPkgName() != "main" && n.InPackages(filter) && !strings.Contains(name, "$") &&
+				unicode.IsUpper([]rune(name)[0]) {
+				return true
+			}
+		default:
+			for _, kind := range n.EntryKinds {
+				if kind == root {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// inModule 判断节点是否在被分析的模块中，模块未知时总是成立
+func (g *DiffGraph) inModule(n *DiffNode) bool {
+	path := n.GetPath()
+	return g.Module == "" || path == g.Module || strings.HasPrefix(path, g.Module+"/")
+}
+
+// FindPaths 为 --pkg 选中的包中每个代码改变、新增或删去的函数找到从入口函数出发的至多 o.PathCount 条最短调用链，
+// 记录在节点的 Paths 中。删去的函数在旧版本的调用图上寻找，其余在新版本的调用图上寻找；调用链只经过调用边
+func (g *DiffGraph) FindPaths(o *common.DiffOptions) error {
+	roots, err := ParsePathRoots(o.PathRoots)
+	if err != nil {
+		return err
+	}
+	for _, node := range g.Nodes {
+		node.Paths = nil
+	}
+	if len(roots) == 0 || o.PathCount <= 0 {
+		return nil
+	}
+	filter := g.PkgFilter(o)
+	var sides [2]*pathSearch
+	for i, old := range []bool{false, true} {
+		sides[i] = g.newPathSearch(old, func(n *DiffNode) bool { return g.isRoot(n, roots, filter) })
+	}
+	for _, node := range g.Nodes {
+		if node.Kind != "" || !node.InPackages(filter) {
+			continue
+		}
+		switch node.Difference {
+		case CHANGED, INSERTED, MOVED, RENAMED:
+			node.Paths = sides[0].shortest(node, o.PathCount)
+		case REMOVED:
+			node.Paths = sides[1].shortest(node, o.PathCount)
+		}
+	}
+	return nil
+}
+
+// pathSearch 某一个版本的调用图上的调用链搜索
+type pathSearch struct {
+	callers  map[*DiffNode][]*DiffNode
+	rootDist map[*DiffNode]int // 从最近的入口函数到该节点的跳数，不可达的节点不在其中
+	isRoot   func(n *DiffNode) bool
+}
+
+// newPathSearch 建立新版本（old 为 false）或旧版本的调用图上的搜索
+func (g *DiffGraph) newPathSearch(old bool, isRoot func(n *DiffNode) bool) *pathSearch {
+	var absent DiffType = INSERTED // 不在该版本中的节点与边
+	if !old {
+		absent = REMOVED
+	}
+	s := &pathSearch{callers: make(map[*DiffNode][]*DiffNode), rootDist: make(map[*DiffNode]int), isRoot: isRoot}
+	var queue []*DiffNode
+	for _, node := range g.Nodes {
+		if node.Difference == absent {
+			continue
+		}
+		if isRoot(node) {
+			s.rootDist[node] = 0
+			queue = append(queue, node)
+		}
+		for _, edge := range node.CallEdge {
+			if edge.Access == "" && edge.Difference != absent && edge.Node.Difference != absent {
+				s.callers[edge.Node] = append(s.callers[edge.Node], node)
+			}
+		}
+	}
+	// 从所有入口函数出发做 BFS
+	callees := make(map[*DiffNode][]*DiffNode)
+	for callee, callers := range s.callers {
+		for _, caller := range callers {
+			callees[caller] = append(callees[caller], callee)
+		}
+	}
+	for len(queue) != 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, callee := range callees[node] {
+			if _, ok := s.rootDist[callee]; !ok {
+				s.rootDist[callee] = s.rootDist[node] + 1
+				queue = append(queue, callee)
+			}
+		}
+	}
+	return s
+}
+
+// partialPath 从目标函数出发沿调用者反向延伸的部分调用链
+type partialPath struct {
+	node   *DiffNode
+	parent *partialPath
+	length int
+	cost   int // length 加上从最近的入口函数到 node 的跳数
+}
+
+func (p *partialPath) contains(n *DiffNode) bool {
+	for ; p != nil; p = p.parent {
+		if p.node == n {
+			return true
+		}
+	}
+	return false
+}
+
+type pathQueue []*partialPath
+
+func (q pathQueue) Len() int { return len(q) }
+func (q pathQueue) Less(i, j int) bool {
+	if q[i].cost != q[j].cost {
+		return q[i].cost < q[j].cost
+	}
+	return q[i].node.Name < q[j].node.Name
+}
+func (q pathQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *pathQueue) Push(x interface{}) { *q = append(*q, x.(*partialPath)) }
+func (q *pathQueue) Pop() interface{} {
+	old := *q
+	p := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return p
+}
+
+// shortest 按长度从短到长返回从入口函数到 target 的至多 k 条不含环的调用链，调用链以入口函数开头。
+// 从 target 沿调用者反向做 A* 搜索，到入口函数的跳数是准确的估价，因此调用链按长度依次得到；到达入口函数后不再延伸
+func (s *pathSearch) shortest(target *DiffNode, k int) [][]string {
+	d, ok := s.rootDist[target]
+	if !ok {
+		return nil
+	}
+	var paths [][]string
+	q := &pathQueue{{node: target, cost: d}}
+	for expanded := 0; q.Len() > 0 && len(paths) < k && expanded < maxPathSearch; expanded++ {
+		p := heap.Pop(q).(*partialPath)
+		if s.isRoot(p.node) {
+			var path []string
+			for ; p != nil; p = p.parent {
+				path = append(path, p.node.Name)
+			}
+			paths = append(paths, path)
+			continue
+		}
+		for _, caller := range s.callers[p.node] {
+			d, ok := s.rootDist[caller]
+			if !ok || p.contains(caller) {
+				continue
+			}
+			heap.Push(q, &partialPath{node: caller, parent: p, length: p.length + 1, cost: p.length + 1 + d})
+		}
+	}
+	return paths
+}
@@ -0,0 +1,68 @@
+package view
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+)
+
+// pathGraph 在 chainGraph 的基础上增加调用 e -> c，a 为 HTTP 处理函数，b 在新版本中不再调用 r
+func pathGraph() *DiffGraph {
+	g := chainGraph()
+	g.CalcAffected()
+	node(g, "e").CallEdge[node(g, "c").Name] = NewDiffEdgeHelper(node(g, "c"))
+	node(g, "a").EntryKinds = []string{RootHandlers}
+	r := NewDiffNodeHelper()
+	r.Name = "example.com/m#main#r#"
+	r.Difference = REMOVED
+	g.Nodes[r.Name] = r
+	node(g, "b").CallEdge[r.Name] = &DiffEdge{Node: r, Difference: REMOVED}
+	return g
+}
+
+func chains(g *DiffGraph, name string) [][]string {
+	var result [][]string
+	for _, path := range node(g, name).Paths {
+		var chain []string
+		for _, n := range path {
+			chain = append(chain, g.Nodes[n].GetFuncName())
+		}
+		result = append(result, chain)
+	}
+	return result
+}
+
+func TestFindPaths(t *testing.T) {
+	g := pathGraph()
+	o := &common.DiffOptions{Pkg: "main", PathRoots: RootMain, PathCount: 2}
+	if err := g.FindPaths(o); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"main", "e", "c", "d"}, {"main", "a", "b", "c", "d"}}
+	if got := chains(g, "d"); !reflect.DeepEqual(got, want) {
+		t.Errorf("d: %v, want %v", got, want)
+	}
+	// 删去的函数在旧版本的调用图上寻找
+	if got := chains(g, "r"); !reflect.DeepEqual(got, [][]string{{"main", "a", "b", "r"}}) {
+		t.Errorf("r: %v", got)
+	}
+	// 受影响的函数没有调用链
+	if len(node(g, "b").Paths) != 0 {
+		t.Errorf("b: %v", chains(g, "b"))
+	}
+
+	// 到达入口函数后不再延伸
+	o.PathRoots, o.PathCount = "main,handlers", 1
+	if err := g.FindPaths(o); err != nil {
+		t.Fatal(err)
+	}
+	if got := chains(g, "r"); !reflect.DeepEqual(got, [][]string{{"a", "b", "r"}}) {
+		t.Errorf("r from handlers: %v", got)
+	}
+
+	o.PathRoots = "main,cron"
+	if err := g.FindPaths(o); err == nil {
+		t.Error("expected an error for an unsupported root")
+	}
+}
@@ -0,0 +1,75 @@
+package view
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+)
+
+// plantUMLEscaper 转义 PlantUML 标签中的特殊字符，PlantUML 的字符串中不能转义双引号，换行写作 \n
+var plantUMLEscaper = strings.NewReplacer(`"`, "'", "\n", `\n`)
+
+// PlantUMLVisualization 将差异以 PlantUML 图的形式写入 w，节点与边的可见性与 Visualization 一致。
+// 每个包为一个 package，函数为椭圆，全局变量、常量与类型为方框，节点按差异种类使用不同的 stereotype 样式
+func (g *DiffGraph) PlantUMLVisualization(w io.Writer, o *common.DiffOptions) error {
+	vg := g.visibleGraph(o)
+	var b strings.Builder
+	b.WriteString("@startuml\nleft to right direction\nhide stereotype\nskinparam shadowing false\n")
+	differences := sortedDifferences()
+	for _, difference := range differences {
+		for _, element := range []string{"usecase", "rectangle"} {
+			fmt.Fprintf(&b, "skinparam %s<<%s>> {\n  BackgroundColor %s\n  BorderColor %s\n", element, differenceName[difference],
+				svgColor(fillColorMap[difference]), svgColor(lineColorMap[difference]))
+			// 移动或重命名的函数使用加粗的边框
+			if _, ok := nodeStyleMap[difference]; ok {
+				b.WriteString("  BorderThickness 3\n")
+			}
+			b.WriteString("}\n")
+		}
+	}
+
+	ids := make(map[*DiffNode]string)
+	for i, n := range vg.nodes {
+		ids[n] = fmt.Sprintf("n%d", i)
+	}
+	paths, clusters := vg.packages()
+	for _, path := range paths {
+		nodes := clusters[path]
+		fmt.Fprintf(&b, "package \"%s\" {\n", plantUMLEscaper.Replace(nodes[0].GetPkgName()+" ("+path+")"))
+		for _, n := range nodes {
+			element := "usecase"
+			if n.Kind != "" {
+				element = "rectangle"
+			}
+			fmt.Fprintf(&b, "  %s \"%s\" as %s <<%s>>", element, plantUMLEscaper.Replace(n.label()), ids[n], differenceName[n.Difference])
+			if vg.highlighted[n] {
+				b.WriteString(" ##[bold]")
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("}\n")
+	}
+
+	for _, e := range vg.edges {
+		style := []string{svgColor(lineColorMap[e.edge.Difference])}
+		switch {
+		case e.edge.Difference == REMOVED:
+			style = append(style, "dashed")
+		case e.edge.Access != "":
+			style = append(style, "dotted")
+		}
+		if e.highlighted {
+			style = append(style, "thickness=3")
+		}
+		fmt.Fprintf(&b, "%s -[%s]-> %s", ids[e.from], strings.Join(style, ","), ids[e.edge.Node])
+		if e.edge.Access == Writes {
+			b.WriteString(" : " + Writes)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("@enduml\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,30 @@
+package view
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+)
+
+func TestPlantUMLVisualization(t *testing.T) {
+	g := removedCallGraph()
+	var b bytes.Buffer
+	if err := g.PlantUMLVisualization(&b, &common.DiffOptions{Pkg: "main", PrintPrivate: true}); err != nil {
+		t.Fatal(err)
+	}
+	diagram := b.String()
+	for _, want := range []string{
+		"@startuml\n",
+		"package \"main (example.com/m)\" {\n",
+		"usecase \"c\" as n2 <<changed>>\n",
+		"usecase \"b\" as n1 <<affected>>\n",
+		"n5 -[#B85450,dashed]-> n4\n",
+		"@enduml\n",
+	} {
+		if !strings.Contains(diagram, want) {
+			t.Errorf("diagram does not contain %q:\n%s", want, diagram)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package view
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// RangeReport 提交范围内逐个提交的差异汇总
+type RangeReport struct {
+	Range   string         `json:"range"`
+	Pkg     string         `json:"pkg"`
+	Algo    string         `json:"algo"`
+	Commits []CommitReport `json:"commits"`
+}
+
+// CommitReport 单个提交相对于其父提交的差异
+type CommitReport struct {
+	Hash       string     `json:"hash"`
+	Parent     string     `json:"parent"`
+	Author     string     `json:"author"`
+	Subject    string     `json:"subject"`
+	ChangeList changeList `json:"change_list"`
+}
+
+// AddCommit 将一个提交的差异加入汇总
+func (r *RangeReport) AddCommit(hash string, parent string, author string, subject string, o Output) {
+	r.Commits = append(r.Commits, CommitReport{
+		Hash:       hash,
+		Parent:     parent,
+		Author:     author,
+		Subject:    subject,
+		ChangeList: o.ChangeList,
+	})
+}
+
+// WriteJSON 以 JSON 格式输出汇总
+func (r *RangeReport) WriteJSON(w io.Writer) error {
+	return writeJSON(w, r)
+}
+
+// WriteMarkdown 以 Markdown 表格与列表的形式输出汇总
+func (r *RangeReport) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# calldiff %s\n\n", r.Range)
+	fmt.Fprintf(&b, "Package: `%s`\n\n", r.Pkg)
+	b.WriteString("| Commit | Author | Subject | New | Deleted | Modified |\n")
+	b.WriteString("| ------ | ------ | ------- | --- | ------- | -------- |\n")
+	for _, c := range r.Commits {
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %d | %d | %d |\n", shortHash(c.Hash), escapeMarkdownCell(c.Author),
+			escapeMarkdownCell(c.Subject), len(c.ChangeList.New), len(c.ChangeList.Deleted), len(c.ChangeList.Modified))
+	}
+	for _, c := range r.Commits {
+		fmt.Fprintf(&b, "\n## %s %s\n\n", shortHash(c.Hash), c.Subject)
+		fmt.Fprintf(&b, "Author: %s, parent: `%s`\n", c.Author, shortHash(c.Parent))
+		writeMarkdownList(&b, "New", c.ChangeList.New)
+		writeMarkdownList(&b, "Deleted", c.ChangeList.Deleted)
+		var modified []string
+		for _, m := range c.ChangeList.Modified {
+			if m.AstChanged {
+				modified = append(modified, m.Name+" (changed)")
+			} else {
+				modified = append(modified, m.Name+" (affected)")
+			}
+		}
+		writeMarkdownList(&b, "Modified", modified)
+		if len(c.ChangeList.New)+len(c.ChangeList.Deleted)+len(c.ChangeList.Modified) == 0 {
+			b.WriteString("\nNo call graph changes.\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeMarkdownList(b *strings.Builder, title string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	sorted := append([]string(nil), items...)
+	sort.Strings(sorted)
+	fmt.Fprintf(b, "\n### %s\n\n", title)
+	for _, item := range sorted {
+		fmt.Fprintf(b, "- `%s`\n", item)
+	}
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 10 {
+		return hash[:10]
+	}
+	return hash
+}
+
+func escapeMarkdownCell(s string) string {
+	return strings.ReplaceAll(s, "|", "\\|")
+}
@@ -0,0 +1,398 @@
+package view
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+)
+
+// SVG 的渲染方式
+const (
+	RendererAuto    = "auto"    // 能找到 dot 命令时使用 dot，否则使用内置的渲染
+	RendererDot     = "dot"     // 调用 Graphviz 的 dot 命令
+	RendererBuiltin = "builtin" // 内置的分层布局，不依赖外部程序，只生成 SVG
+)
+
+// Renderers 支持的 SVG 渲染方式
+var Renderers = []string{RendererAuto, RendererDot, RendererBuiltin}
+
+// CheckRenderer 检查 SVG 的渲染方式是否受支持，为空时视为 auto
+func CheckRenderer(renderer string) error {
+	if renderer == "" {
+		return nil
+	}
+	for _, r := range Renderers {
+		if r == renderer {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported renderer %q, supported are %s", renderer, strings.Join(Renderers, ", "))
+}
+
+// useDot 判断按 renderer 渲染 SVG 时是否调用 dot 命令
+func useDot(renderer string) (bool, error) {
+	if err := CheckRenderer(renderer); err != nil {
+		return false, err
+	}
+	switch renderer {
+	case RendererDot:
+		return true, nil
+	case RendererBuiltin:
+		return false, nil
+	}
+	_, err := exec.LookPath("dot")
+	return err == nil, nil
+}
+
+// 内置渲染的尺寸，单位为像素
+const (
+	svgMargin      = 20
+	svgFontSize    = 12
+	svgCharWidth   = 7  // 按等宽字体估计的字符宽度
+	svgLineHeight  = 14 // 多行标签的行距
+	svgNodePadding = 32 // 椭圆的宽度比文字多出的部分
+	svgMinWidth    = 60
+	svgRowHeight   = 64 // 同一列中相邻节点的间距
+	svgColumnGap   = 80 // 相邻两层之间的间距
+	svgClusterPad  = 16 // 包的框与其中节点的间距
+	svgClusterHead = 30 // 包的框顶部放置包名的高度
+	svgClusterGap  = 20 // 相邻两个包的框之间的间距
+)
+
+// svgNode 节点的布局
+type svgNode struct {
+	node  *DiffNode
+	lines []string
+	layer int
+	x, y  int // 中心的坐标
+	w, h  int
+}
+
+// svgCluster 一个包占据的横向区域，各层的节点在其中纵向排列
+type svgCluster struct {
+	path   string
+	label  string
+	layers map[int][]*svgNode
+	rows   int // 节点最多的一层的节点数
+	top    int
+	height int
+}
+
+// svgLayout 内置渲染的分层布局：调用者在左，被调用者在右，每个包占据一个横向区域
+type svgLayout struct {
+	nodes    map[*DiffNode]*svgNode
+	clusters []*svgCluster
+	width    int
+	height   int
+}
+
+// layoutGraph 对要显示的图做分层布局：
+// 去除 DFS 中的回边使图无环，按最长路径分层，各包的区域从上到下排列，同一层中的节点按已放置的相邻节点的平均高度排序
+func layoutGraph(vg *visibleGraph) *svgLayout {
+	l := &svgLayout{nodes: make(map[*DiffNode]*svgNode)}
+	succ := make(map[*DiffNode][]*DiffNode)
+	for _, e := range vg.edges {
+		succ[e.from] = append(succ[e.from], e.edge.Node)
+	}
+	for _, n := range vg.nodes {
+		lines := strings.Split(n.label(), "\n")
+		w := 0
+		for _, line := range lines {
+			if len(line) > w {
+				w = len(line)
+			}
+		}
+		w = w*svgCharWidth + svgNodePadding
+		if w < svgMinWidth {
+			w = svgMinWidth
+		}
+		l.nodes[n] = &svgNode{node: n, lines: lines, w: w, h: 24 + svgLineHeight*len(lines)}
+	}
+
+	// 去除回边，得到无环图中各节点的前驱
+	pred := make(map[*DiffNode][]*DiffNode)
+	state := make(map[*DiffNode]int) // 1 为在 DFS 的栈中，2 为已完成
+	var dfs func(n *DiffNode)
+	dfs = func(n *DiffNode) {
+		state[n] = 1
+		for _, m := range succ[n] {
+			switch state[m] {
+			case 0:
+				pred[m] = append(pred[m], n)
+				dfs(m)
+			case 2:
+				pred[m] = append(pred[m], n)
+			}
+		}
+		state[n] = 2
+	}
+	for _, n := range vg.nodes {
+		if state[n] == 0 {
+			dfs(n)
+		}
+	}
+	// 最长路径分层
+	layered := make(map[*DiffNode]bool)
+	var layer func(n *DiffNode) int
+	layer = func(n *DiffNode) int {
+		sn := l.nodes[n]
+		if layered[n] {
+			return sn.layer
+		}
+		layered[n] = true
+		for _, p := range pred[n] {
+			if d := layer(p) + 1; d > sn.layer {
+				sn.layer = d
+			}
+		}
+		return sn.layer
+	}
+	layers := 0
+	for _, n := range vg.nodes {
+		if d := layer(n) + 1; d > layers {
+			layers = d
+		}
+	}
+
+	// 各层的宽度与横坐标
+	widths := make([]int, layers)
+	for _, sn := range l.nodes {
+		if sn.w > widths[sn.layer] {
+			widths[sn.layer] = sn.w
+		}
+	}
+	xs := make([]int, layers)
+	x := svgMargin + svgClusterPad
+	for i, w := range widths {
+		xs[i] = x + w/2
+		x += w + svgColumnGap
+	}
+	l.width = x - svgColumnGap + svgClusterPad + svgMargin
+	if layers == 0 {
+		l.width = 2 * svgMargin
+	}
+
+	// 按包划分区域
+	clusters := make(map[string]*svgCluster)
+	for _, n := range vg.nodes {
+		c, ok := clusters[n.GetPath()]
+		if !ok {
+			c = &svgCluster{
+				path:   n.GetPath(),
+				label:  n.GetPkgName() + " (" + n.GetPath() + ")",
+				layers: make(map[int][]*svgNode),
+			}
+			clusters[c.path] = c
+			l.clusters = append(l.clusters, c)
+		}
+		sn := l.nodes[n]
+		c.layers[sn.layer] = append(c.layers[sn.layer], sn)
+		if len(c.layers[sn.layer]) > c.rows {
+			c.rows = len(c.layers[sn.layer])
+		}
+	}
+	sort.Slice(l.clusters, func(i, j int) bool { return l.clusters[i].path < l.clusters[j].path })
+	y := svgMargin
+	for _, c := range l.clusters {
+		c.top = y
+		c.height = svgClusterHead + c.rows*svgRowHeight + svgClusterPad
+		y += c.height + svgClusterGap
+	}
+	l.height = y - svgClusterGap + svgMargin
+	if len(l.clusters) == 0 {
+		l.height = 2 * svgMargin
+	}
+
+	// 逐层放置节点，同一层中的节点按已放置的相邻节点的平均纵坐标排序以减少交叉
+	neighbours := make(map[*DiffNode][]*DiffNode)
+	for _, e := range vg.edges {
+		neighbours[e.from] = append(neighbours[e.from], e.edge.Node)
+		neighbours[e.edge.Node] = append(neighbours[e.edge.Node], e.from)
+	}
+	placed := make(map[*DiffNode]bool)
+	for i := 0; i < layers; i++ {
+		for _, c := range l.clusters {
+			nodes := c.layers[i]
+			bary := make(map[*svgNode]float64)
+			for _, sn := range nodes {
+				sum, count := 0, 0
+				for _, m := range neighbours[sn.node] {
+					if placed[m] {
+						sum += l.nodes[m].y
+						count++
+					}
+				}
+				bary[sn] = -1
+				if count > 0 {
+					bary[sn] = float64(sum) / float64(count)
+				}
+			}
+			// 没有已放置的相邻节点的排在最后
+			sort.SliceStable(nodes, func(a, b int) bool {
+				ba, bb := bary[nodes[a]], bary[nodes[b]]
+				if (ba < 0) != (bb < 0) {
+					return bb < 0
+				}
+				return ba < bb
+			})
+			// 节点数少于该包最多的一层时，在区域中居中
+			offset := (c.rows - len(nodes)) * svgRowHeight / 2
+			for j, sn := range nodes {
+				sn.x = xs[i]
+				sn.y = c.top + svgClusterHead + offset + j*svgRowHeight + svgRowHeight/2
+				placed[sn.node] = true
+			}
+		}
+	}
+	return l
+}
+
+// edgeDash 返回边的虚线样式：删去的边为虚线，对全局变量、常量与类型的访问为点线，其余为实线
+func edgeDash(edge *DiffEdge) string {
+	switch {
+	case edge.Difference == REMOVED:
+		return "6,4"
+	case edge.Access != "":
+		return "2,3"
+	}
+	return ""
+}
+
+// edgeGeometry 返回从 from 到 to 的边的路径，以及放置边上标签的位置
+func edgeGeometry(from *svgNode, to *svgNode) (path string, labelX int, labelY int) {
+	switch {
+	case from == to:
+		// 递归调用画为节点右上方的环
+		x, y := from.x+from.w/4, from.y-from.h/2
+		path = fmt.Sprintf("M%d,%d C%d,%d %d,%d %d,%d", x, y, x, y-40, x+from.w/2, y-40, from.x+from.w/2, from.y)
+		return path, x + from.w/4, y - 30
+	case to.layer > from.layer:
+		x1, y1, x2, y2 := from.x+from.w/2, from.y, to.x-to.w/2, to.y
+		dx := (x2 - x1) / 2
+		path = fmt.Sprintf("M%d,%d C%d,%d %d,%d %d,%d", x1, y1, x1+dx, y1, x2-dx, y2, x2, y2)
+		return path, (x1 + x2) / 2, (y1+y2)/2 - 4
+	default:
+		// 回边与同一层中的边从下方绕行
+		x1, y1, x2, y2 := from.x, from.y+from.h/2, to.x, to.y+to.h/2
+		bottom := y1
+		if y2 > bottom {
+			bottom = y2
+		}
+		bottom += svgRowHeight / 2
+		path = fmt.Sprintf("M%d,%d C%d,%d %d,%d %d,%d", x1, y1, x1, bottom, x2, bottom, x2, y2)
+		return path, (x1 + x2) / 2, bottom - 4
+	}
+}
+
+// svgColor 将 Graphviz 属性写法的颜色去掉引号
+func svgColor(color string) string {
+	if s, err := strconv.Unquote(color); err == nil {
+		return s
+	}
+	return color
+}
+
+// arrowID 返回颜色对应的箭头的 id
+func arrowID(color string) string {
+	return "arrow" + strings.TrimPrefix(color, "#")
+}
+
+// RenderBuiltinSVG 不依赖 dot 命令，使用内置的分层布局将差异渲染为 SVG 并写入 w，不支持 PNG 等位图格式。
+// 节点、边的可见性与颜色与 Visualization 一致，每个包为一个框，删去的边为虚线，对全局变量、常量与类型的访问为点线
+func (g *DiffGraph) RenderBuiltinSVG(w io.Writer, o *common.DiffOptions) error {
+	vg := g.visibleGraph(o)
+	l := layoutGraph(vg)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8" standalone="no"?>`+"\n")
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="monospace" font-size="%d">`+"\n",
+		l.width, l.height, l.width, l.height, svgFontSize)
+
+	// 每种颜色的箭头
+	var colors []string
+	for _, color := range lineColorMap {
+		colors = append(colors, svgColor(color))
+	}
+	sort.Strings(colors)
+	b.WriteString("<defs>\n")
+	for i, color := range colors {
+		if i > 0 && color == colors[i-1] {
+			continue
+		}
+		fmt.Fprintf(&b, `<marker id="%s" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="8" markerHeight="8" markerUnits="userSpaceOnUse" orient="auto">`+
+			`<path d="M0,0 L10,5 L0,10 z" fill="%s"/></marker>`+"\n", arrowID(color), color)
+	}
+	b.WriteString("</defs>\n")
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="#FFFFFF"/>`+"\n")
+
+	for _, c := range l.clusters {
+		fmt.Fprintf(&b, `<g class="cluster"><title>%s</title>`, html.EscapeString(c.path))
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="none" stroke="#000000"/>`,
+			svgMargin, c.top, l.width-2*svgMargin, c.height)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="middle">%s</text></g>`+"\n",
+			l.width/2, c.top+svgClusterHead*2/3, html.EscapeString(c.label))
+	}
+
+	for _, e := range vg.edges {
+		from, to := l.nodes[e.from], l.nodes[e.edge.Node]
+		color := svgColor(lineColorMap[e.edge.Difference])
+		width := 1
+		if e.highlighted {
+			width = 3
+		}
+		dash := ""
+		if d := edgeDash(e.edge); d != "" {
+			dash = ` stroke-dasharray="` + d + `"`
+		}
+		path, labelX, labelY := edgeGeometry(from, to)
+		fmt.Fprintf(&b, `<g class="edge"><title>%s</title><path d="%s" fill="none" stroke="%s" stroke-width="%d"%s marker-end="url(#%s)"/>`,
+			html.EscapeString(prettyName(e.from.Name)+" -> "+prettyName(e.edge.Node.Name)), path, color, width, dash, arrowID(color))
+		if e.edge.Access == Writes {
+			fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="middle" fill="%s">%s</text>`, labelX, labelY, color, Writes)
+		}
+		b.WriteString("</g>\n")
+	}
+
+	for _, n := range vg.nodes {
+		sn := l.nodes[n]
+		stroke, fill := svgColor(lineColorMap[n.Difference]), svgColor(fillColorMap[n.Difference])
+		width := 1
+		if vg.highlighted[n] {
+			width = 3
+		}
+		title := n.tooltip(g)
+		if title == "" {
+			title = prettyName(n.Name)
+		}
+		fmt.Fprintf(&b, `<g class="node"><title>%s</title>`, html.EscapeString(title))
+		shape := func(grow int, fill string) {
+			if n.Kind != "" {
+				// 全局变量、常量与类型使用方框
+				fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s" stroke="%s" stroke-width="%d"/>`,
+					sn.x-sn.w/2-grow, sn.y-sn.h/2-grow, sn.w+2*grow, sn.h+2*grow, fill, stroke, width)
+			} else {
+				fmt.Fprintf(&b, `<ellipse cx="%d" cy="%d" rx="%d" ry="%d" fill="%s" stroke="%s" stroke-width="%d"/>`,
+					sn.x, sn.y, sn.w/2+grow, sn.h/2+grow, fill, stroke, width)
+			}
+		}
+		// 移动或重命名的函数使用双线边框
+		if _, ok := nodeStyleMap[n.Difference]; ok {
+			shape(4, "none")
+		}
+		shape(0, fill)
+		top := sn.y - (len(sn.lines)-1)*svgLineHeight/2 + svgFontSize/3
+		for i, line := range sn.lines {
+			fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="middle">%s</text>`, sn.x, top+i*svgLineHeight, html.EscapeString(line))
+		}
+		b.WriteString("</g>\n")
+	}
+	b.WriteString("</svg>\n")
+	_, err := w.Write(b.Bytes())
+	return err
+}
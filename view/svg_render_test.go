@@ -0,0 +1,57 @@
+package view
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/bytecamp2021-calldiff/calldiff/common"
+)
+
+func TestRenderBuiltinSVG(t *testing.T) {
+	g := chainGraph()
+	g.CalcAffected()
+	// 删去的调用 main -> d 与另一个包中新增的函数 f
+	removed := NewDiffEdgeHelper(node(g, "d"))
+	removed.Difference = REMOVED
+	node(g, "main").CallEdge[node(g, "d").Name] = removed
+	f := NewDiffNodeHelper()
+	f.Name = "example.com/m/util#util#F#"
+	f.Difference = INSERTED
+	g.Nodes[f.Name] = f
+	added := NewDiffEdgeHelper(f)
+	added.Difference = INSERTED
+	node(g, "d").CallEdge[f.Name] = added
+
+	var b bytes.Buffer
+	o := &common.DiffOptions{Pkg: "main,util", PrintPrivate: true, Renderer: RendererBuiltin}
+	if err := g.RenderSVG(&b, o); err != nil {
+		t.Fatal(err)
+	}
+	svg := b.String()
+	// 输出是合法的 XML
+	decoder := xml.NewDecoder(strings.NewReader(svg))
+	for {
+		if _, err := decoder.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("invalid svg: %v\n%s", err, svg)
+		}
+	}
+	for _, want := range []string{">main (example.com/m)<", ">util (example.com/m/util)<", ">F<", ">d<", `stroke-dasharray="6,4"`} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("svg does not contain %s:\n%s", want, svg)
+		}
+	}
+	// 未受影响的 e 不输出
+	if strings.Contains(svg, ">e<") {
+		t.Errorf("unchanged node e should be hidden:\n%s", svg)
+	}
+
+	o.Renderer = "png"
+	if err := g.RenderSVG(&b, o); err == nil {
+		t.Error("unsupported renderer should fail")
+	}
+}